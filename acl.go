@@ -0,0 +1,140 @@
+package longpoll
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ErrTopicDenied Returned when a TopicAuthorizer denies a subscription or publish (403)
+var ErrTopicDenied = errors.New("topic access denied")
+
+// TopicAttribute Attribute naming the topic of a message published by a peer.
+// POSTed messages with this attribute are checked with TopicAuthorizer.CanPublish
+const TopicAttribute = "topic"
+
+// TopicAuthorizer Decides which peers may subscribe and publish to which topics
+type TopicAuthorizer interface {
+	CanSubscribe(peerUUID string, topic string) error
+	CanPublish(peerUUID string, topic string) error
+}
+
+// TopicRule Grants a peer access to a topic. Patterns use path.Match syntax eg: "tenant-a/*".
+// "{peer}" in the Topic pattern is replaced with the peer UUID eg: "peers/{peer}/*"
+type TopicRule struct {
+	Peer      string // Peer UUID pattern
+	Topic     string // Topic pattern
+	Subscribe bool   // Allow subscribing
+	Publish   bool   // Allow publishing
+}
+
+// StaticTopicRules A TopicAuthorizer that allows access granted by any rule and denies everything else
+type StaticTopicRules []TopicRule
+
+// CanSubscribe Implements TopicAuthorizer
+func (r StaticTopicRules) CanSubscribe(peerUUID string, topic string) error {
+	return r.check(peerUUID, topic, false)
+}
+
+// CanPublish Implements TopicAuthorizer
+func (r StaticTopicRules) CanPublish(peerUUID string, topic string) error {
+	return r.check(peerUUID, topic, true)
+}
+
+func (r StaticTopicRules) check(peerUUID string, topic string, publish bool) error {
+	for _, rule := range r {
+		if publish && !rule.Publish || !publish && !rule.Subscribe {
+			continue
+		}
+		peerMatch, _ := path.Match(rule.Peer, peerUUID)
+		if !peerMatch {
+			continue
+		}
+		topicMatch, _ := path.Match(strings.ReplaceAll(rule.Topic, "{peer}", patternEscaper.Replace(peerUUID)), topic)
+		if topicMatch {
+			return nil
+		}
+	}
+	return ErrTopicDenied
+}
+
+// Escapes the path.Match metacharacters in a peer UUID so "{peer}" only matches the UUID itself eg: a UUID of "*"
+var patternEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`)
+
+// TopicAuthorizerFunc A TopicAuthorizer backed by a function. publish is false for subscriptions
+type TopicAuthorizerFunc func(peerUUID string, topic string, publish bool) error
+
+// CanSubscribe Implements TopicAuthorizer
+func (f TopicAuthorizerFunc) CanSubscribe(peerUUID string, topic string) error {
+	return f(peerUUID, topic, false)
+}
+
+// CanPublish Implements TopicAuthorizer
+func (f TopicAuthorizerFunc) CanPublish(peerUUID string, topic string) error {
+	return f(peerUUID, topic, true)
+}
+
+// PublishMessage Forwards a message published by a peer to all other peers subscribed to a topic
+func (m *Manager) PublishMessage(publisherUUID string, topic string, message Message) error {
+	// Check the publisher may publish to the topic
+	err := m.checkPublish(publisherUUID, topic)
+	if err != nil {
+		return err
+	}
+
+	// Find the subscribers
+	var subscribers []string
+	m.peersMU.RLock()
+	for _, peer := range m.peers {
+		if peer.UUID != publisherUUID && peer.Online && peer.subscribed(topic) {
+			subscribers = append(subscribers, peer.UUID)
+		}
+	}
+	m.peersMU.RUnlock()
+
+	// Forward the message
+	for _, subscriber := range subscribers {
+		go func() {
+			err := m.Forward(subscriber, message)
+			if err != nil {
+				m.messageDropped(subscriber, message.MessageID, "publish", "forward_failed", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// Checks a peer may subscribe to a topic
+func (m *Manager) checkSubscribe(peerUUID string, topic string) error {
+	if m.TopicAuthorizer == nil {
+		return nil
+	}
+	err := m.TopicAuthorizer.CanSubscribe(peerUUID, topic)
+	if err != nil {
+		err = topicDenied("subscribe", topic, err)
+		m.notifyReject(peerUUID, "", err)
+	}
+	return err
+}
+
+// Checks a peer may publish to a topic
+func (m *Manager) checkPublish(peerUUID string, topic string) error {
+	if m.TopicAuthorizer == nil {
+		return nil
+	}
+	err := m.TopicAuthorizer.CanPublish(peerUUID, topic)
+	if err != nil {
+		err = topicDenied("publish", topic, err)
+		m.notifyReject(peerUUID, "", err)
+	}
+	return err
+}
+
+// Wraps an authorizer error so it always matches ErrTopicDenied
+func topicDenied(action string, topic string, err error) error {
+	if !errors.Is(err, ErrTopicDenied) {
+		err = fmt.Errorf("%w: %w", ErrTopicDenied, err)
+	}
+	return fmt.Errorf("%s %s: %w", action, topic, err)
+}
@@ -0,0 +1,43 @@
+package longpoll
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStaticTopicRules(t *testing.T) {
+	rules := StaticTopicRules{
+		{Peer: "*", Topic: "peers/{peer}/*", Subscribe: true, Publish: true},
+		{Peer: "sensor-*", Topic: "telemetry/*", Publish: true},
+	}
+	tests := []struct {
+		name    string
+		peer    string
+		topic   string
+		publish bool
+		want    error
+	}{
+		{"own topic", "alice", "peers/alice/inbox", true, nil},
+		{"other peer's topic", "alice", "peers/bob/inbox", true, ErrTopicDenied},
+		{"wildcard uuid", "*", "peers/victim/inbox", true, ErrTopicDenied},
+		{"single character uuid", "?????", "peers/alice/inbox", false, ErrTopicDenied},
+		{"character class uuid", "[a-z]lice", "peers/alice/inbox", false, ErrTopicDenied},
+		{"literal wildcard uuid", "*", "peers/*/inbox", true, nil},
+		{"peer pattern", "sensor-1", "telemetry/temp", true, nil},
+		{"publish only rule", "sensor-1", "telemetry/temp", false, ErrTopicDenied},
+		{"no rule", "alice", "telemetry/temp", true, ErrTopicDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.publish {
+				err = rules.CanPublish(tt.peer, tt.topic)
+			} else {
+				err = rules.CanSubscribe(tt.peer, tt.topic)
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package longpoll
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PeerInfo A snapshot of a peer's state
+type PeerInfo struct {
+	UUID              string            `json:"uuid"`
+	IPAddr            string            `json:"ip_addr,omitempty"`
+	IsServer          bool              `json:"is_server"`
+	ServerURL         string            `json:"server_url,omitempty"`
+	Online            bool              `json:"online"`
+	LastConsumed      time.Time         `json:"last_consumed"`
+	QueueDepth        int               `json:"queue_depth"`
+	Topics            []string          `json:"topics"`
+	StickyAttributes  map[string]string `json:"sticky_attributes"`
+	RemoteManagerUUID string            `json:"remote_manager_uuid,omitempty"`
+	Settings          PeerSettings      `json:"settings"`
+}
+
+// TopicInfo A topic and the number of peers subscribed to it
+type TopicInfo struct {
+	Topic       string `json:"topic"`
+	Subscribers int    `json:"subscribers"`
+}
+
+// Peers Lists all peers sorted by UUID
+func (m *Manager) Peers() []PeerInfo {
+	m.peersMU.RLock()
+	infos := make([]PeerInfo, 0, len(m.peers))
+	for _, peer := range m.peers {
+		infos = append(infos, peer.info())
+	}
+	m.peersMU.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].UUID < infos[j].UUID
+	})
+	return infos
+}
+
+// GetPeerInfo Gets the state of a peer
+func (m *Manager) GetPeerInfo(uuid string) (PeerInfo, error) {
+	m.peersMU.RLock()
+	defer m.peersMU.RUnlock()
+	peer, _ := m.peers[uuid]
+	if peer == nil {
+		return PeerInfo{}, errors.New("peer not found")
+	}
+
+	return peer.info(), nil
+}
+
+// Topics Lists all topics with their subscriber counts sorted by topic
+func (m *Manager) Topics() []TopicInfo {
+	counts := make(map[string]int)
+	m.peersMU.RLock()
+	for _, peer := range m.peers {
+		for _, topic := range peer.Topics {
+			counts[topic]++
+		}
+	}
+	m.peersMU.RUnlock()
+
+	topics := make([]TopicInfo, 0, len(counts))
+	for topic, count := range counts {
+		topics = append(topics, TopicInfo{Topic: topic, Subscribers: count})
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Topic < topics[j].Topic
+	})
+	return topics
+}
+
+// PeekQueue Returns the messages waiting in a client peer's queue, oldest first, without taking them
+func (m *Manager) PeekQueue(uuid string) ([]Message, error) {
+	peer, err := m.clientPeer(uuid)
+	if err != nil {
+		return nil, err
+	}
+	return peer.peekQueue(), nil
+}
+
+// PurgeQueue Discards the messages waiting in a client peer's queue and returns how many were discarded
+func (m *Manager) PurgeQueue(uuid string) (int, error) {
+	peer, err := m.clientPeer(uuid)
+	if err != nil {
+		return 0, err
+	}
+
+	messages := peer.drainQueue()
+	for _, msg := range messages {
+		m.messageDropped(uuid, msg.MessageID, "purge", "purged", nil)
+	}
+	return len(messages), nil
+}
+
+// KickPeer Disconnects a client peer: its in-flight poll ends, its queue is discarded and DownCallback is called.
+// The peer is created again when it next polls
+func (m *Manager) KickPeer(uuid string) error {
+	m.peersMU.Lock()
+	defer m.peersMU.Unlock()
+	peer, _ := m.peers[uuid]
+	if peer == nil {
+		return errors.New("peer not found")
+	}
+	if peer.IsServer {
+		return errors.New("cannot kick a server peer, use DeletePeer")
+	}
+
+	// Remove the peer
+	for _, msg := range peer.drainQueue() {
+		m.messageDropped(uuid, msg.MessageID, "kick", "peer_removed", nil)
+	}
+	m.removePeer(peer)
+	m.emit(Event{Type: EventPeerOffline, PeerUUID: uuid, IPAddr: peer.getIPAddr(), Reason: ReasonKicked})
+
+	// Call the manager down callback
+	if m.DownCallback != nil {
+		go m.DownCallback(uuid)
+	}
+	return nil
+}
+
+// AdminHandler Returns the admin API as a http.Handler with routes relative to the root eg: /peers
+func (m *Manager) AdminHandler() http.Handler {
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	if m.AdminMiddleware != nil {
+		r.Use(m.AdminMiddleware)
+	}
+	m.adminRoutes(r.Group("/"))
+	return r
+}
+
+// Adds the admin routes to a router group
+func (m *Manager) adminRoutes(g *gin.RouterGroup) {
+	g.GET("/peers", func(c *gin.Context) {
+		c.JSON(200, m.Peers())
+	})
+
+	g.GET("/peers/:uuid", func(c *gin.Context) {
+		info, err := m.GetPeerInfo(c.Param("uuid"))
+		if err != nil {
+			adminError(c, 404, err)
+			return
+		}
+		c.JSON(200, info)
+	})
+
+	g.DELETE("/peers/:uuid", func(c *gin.Context) {
+		err := m.DeletePeer(c.Param("uuid"))
+		if err != nil {
+			adminError(c, 404, err)
+			return
+		}
+		c.Status(204)
+	})
+
+	g.POST("/peers/:uuid/kick", func(c *gin.Context) {
+		err := m.KickPeer(c.Param("uuid"))
+		if err != nil {
+			adminError(c, 400, err)
+			return
+		}
+		c.Status(204)
+	})
+
+	g.GET("/peers/:uuid/settings", func(c *gin.Context) {
+		settings, err := m.GetPeerSettings(c.Param("uuid"))
+		if err != nil {
+			adminError(c, 404, err)
+			return
+		}
+		c.JSON(200, settings)
+	})
+
+	g.PUT("/peers/:uuid/settings", func(c *gin.Context) {
+		var settings PeerSettings
+		err := c.ShouldBindJSON(&settings)
+		if err != nil {
+			adminError(c, 400, err)
+			return
+		}
+		err = m.SetPeerSettings(c.Param("uuid"), settings)
+		if err != nil {
+			adminError(c, 400, err)
+			return
+		}
+		c.JSON(200, settings)
+	})
+
+	g.GET("/peers/:uuid/queue", func(c *gin.Context) {
+		messages, err := m.PeekQueue(c.Param("uuid"))
+		if err != nil {
+			adminError(c, 400, err)
+			return
+		}
+		c.JSON(200, messages)
+	})
+
+	g.DELETE("/peers/:uuid/queue", func(c *gin.Context) {
+		purged, err := m.PurgeQueue(c.Param("uuid"))
+		if err != nil {
+			adminError(c, 400, err)
+			return
+		}
+		c.JSON(200, gin.H{
+			"purged": purged,
+		})
+	})
+
+	g.GET("/topics", func(c *gin.Context) {
+		c.JSON(200, m.Topics())
+	})
+
+	g.POST("/drain", func(c *gin.Context) {
+		var body struct {
+			URL string `json:"url"`
+		}
+		err := c.ShouldBindJSON(&body)
+		if err != nil {
+			adminError(c, 400, err)
+			return
+		}
+		err = m.Drain(body.URL)
+		if err != nil {
+			adminError(c, 400, err)
+			return
+		}
+		c.Status(204)
+	})
+}
+
+func adminError(c *gin.Context, status int, err error) {
+	c.JSON(status, gin.H{
+		"error": err.Error(),
+	})
+}
+
+// Gets a client peer, which has a queue
+func (m *Manager) clientPeer(uuid string) (*Peer, error) {
+	m.peersMU.RLock()
+	peer, _ := m.peers[uuid]
+	m.peersMU.RUnlock()
+	if peer == nil {
+		return nil, errors.New("peer not found")
+	}
+	if peer.IsServer {
+		return nil, errors.New("server peers have no queue")
+	}
+	return peer, nil
+}
+
+// Snapshots the peer state. Must be called with peersMU held
+func (p *Peer) info() PeerInfo {
+	info := PeerInfo{
+		UUID:              p.UUID,
+		IPAddr:            p.getIPAddr(),
+		IsServer:          p.IsServer,
+		ServerURL:         p.serverURL(),
+		Online:            p.Online,
+		LastConsumed:      p.getLastConsumed(),
+		Topics:            append([]string{}, p.Topics...),
+		StickyAttributes:  make(map[string]string),
+		RemoteManagerUUID: p.getRemoteManagerUUID(),
+		Settings:          p.getSettings(),
+	}
+	if p.Ch != nil {
+		info.QueueDepth = len(p.Ch)
+	}
+	for k, v := range p.stickyAttributes() {
+		info.StickyAttributes[k] = v
+	}
+	return info
+}
@@ -0,0 +1,120 @@
+package longpoll
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminRequiresMiddleware(t *testing.T) {
+	_, err := NewManager(WithAdminPath("/admin"))
+	if err == nil {
+		t.Fatal("admin path accepted without AdminMiddleware")
+	}
+	_, err = NewManager(WithAdminPort(9001))
+	if err == nil {
+		t.Fatal("admin port accepted without AdminMiddleware")
+	}
+	_, err = NewManager(WithAdminPath("/admin"), WithAdminMiddleware(func(c *gin.Context) { c.Next() }))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPeekQueue(t *testing.T) {
+	m, err := NewManager(WithOutboundBufferSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := &Peer{UUID: "client", Ch: make(chan Message, 100), removed: make(chan struct{})}
+	m.peers["client"] = peer
+	for i := 0; i < 3; i++ {
+		err := m.deliver(context.Background(), peer, Message{MessageID: strconv.Itoa(i)}, "send")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Peeking leaves the queue in place and in order
+	for i := 0; i < 2; i++ {
+		messages, err := m.PeekQueue("client")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 3 || messages[0].MessageID != "0" || messages[2].MessageID != "2" {
+			t.Fatalf("got %+v", messages)
+		}
+	}
+	if len(peer.Ch) != 3 {
+		t.Fatalf("queue depth %d, want 3", len(peer.Ch))
+	}
+
+	// Receiving removes the oldest message
+	msg := <-peer.Ch
+	peer.dequeued()
+	messages, _ := m.PeekQueue("client")
+	if msg.MessageID != "0" || len(messages) != 2 || messages[0].MessageID != "1" {
+		t.Fatalf("got %s then %+v", msg.MessageID, messages)
+	}
+
+	n, err := m.PurgeQueue("client")
+	if err != nil || n != 2 {
+		t.Fatalf("purged %d, %v", n, err)
+	}
+	if messages, _ := m.PeekQueue("client"); len(messages) != 0 {
+		t.Fatalf("got %+v after purge", messages)
+	}
+}
+
+func TestPeekQueueConcurrent(t *testing.T) {
+	m, err := NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := &Peer{UUID: "client", Ch: make(chan Message, 4), removed: make(chan struct{})}
+	m.peers["client"] = peer
+
+	// Senders wait for space while a reader takes messages in order and others peek
+	const total = 200
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < total; i++ {
+			err := m.deliver(context.Background(), peer, Message{MessageID: strconv.Itoa(i)}, "send")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			messages, _ := m.PeekQueue("client")
+			if len(messages) > cap(peer.Ch) {
+				t.Errorf("peeked %d messages from a queue of %d", len(messages), cap(peer.Ch))
+			}
+		}
+	}()
+	for i := 0; i < total; i++ {
+		msg := <-peer.Ch
+		peer.dequeued()
+		if msg.MessageID != strconv.Itoa(i) {
+			t.Fatalf("got message %s, want %d", msg.MessageID, i)
+		}
+	}
+	wg.Wait()
+	close(done)
+	if messages, _ := m.PeekQueue("client"); len(messages) != 0 {
+		t.Fatalf("got %+v from an empty queue", messages)
+	}
+}
@@ -0,0 +1,160 @@
+package longpoll
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrIPDenied      = errors.New("ip address denied")                              // IP is denied or not allowed (403)
+	ErrMaxPeers      = errors.New("maximum number of peers reached")                // Manager.MaxPeers reached (503)
+	ErrMaxPeersPerIP = errors.New("maximum number of peers for ip address reached") // Manager.MaxPeersPerIP reached (429)
+)
+
+// RejectError An error that rejects a request with a specific HTTP status.
+// Return one from Manager.AdmissionHook to choose the status (other errors reply 403)
+type RejectError struct {
+	Status int
+	Err    error
+}
+
+func (e *RejectError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RejectError) Unwrap() error {
+	return e.Err
+}
+
+// Checks whether a request may reach an existing or new peer. Safe to call without holding peersMU
+func (m *Manager) checkIP(ipAddr string) error {
+	m.settingsMU.RLock()
+	defer m.settingsMU.RUnlock()
+
+	ip := net.ParseIP(ipAddr)
+	if ip == nil {
+		if len(m.allowedNets) > 0 {
+			return ErrIPDenied
+		}
+		return nil
+	}
+
+	// Check the deny list
+	for _, n := range m.deniedNets {
+		if n.Contains(ip) {
+			return ErrIPDenied
+		}
+	}
+
+	// Check the allow list
+	if len(m.allowedNets) == 0 {
+		return nil
+	}
+	for _, n := range m.allowedNets {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	return ErrIPDenied
+}
+
+// Runs the admission hook for a new peer
+func (m *Manager) checkAdmissionHook(r *http.Request, uuid string) error {
+	if m.AdmissionHook == nil {
+		return nil
+	}
+	return m.AdmissionHook(r, uuid)
+}
+
+// Checks peer limits before creating a new peer. Must be called with peersMU held
+func (m *Manager) checkPeerLimits(ipAddr string) error {
+	m.settingsMU.RLock()
+	defer m.settingsMU.RUnlock()
+
+	if m.MaxPeers > 0 && len(m.peers) >= m.MaxPeers {
+		return ErrMaxPeers
+	}
+	if m.MaxPeersPerIP > 0 {
+		count := 0
+		for _, peer := range m.peers {
+			if !peer.IsServer && peer.getIPAddr() == ipAddr {
+				count++
+			}
+		}
+		if count >= m.MaxPeersPerIP {
+			return ErrMaxPeersPerIP
+		}
+	}
+	return nil
+}
+
+// Replies to a rejected request and calls the reject callback
+func (m *Manager) reject(c *gin.Context, uuid string, err error) {
+	// Pick the status
+	status := 403
+	var rejectErr *RejectError
+	var rateErr *RateLimitError
+	switch {
+	case errors.As(err, &rateErr):
+		status = 429
+		c.Header("Retry-After", formatRetryAfter(rateErr.RetryAfter))
+	case errors.As(err, &rejectErr):
+		status = rejectErr.Status
+	case errors.Is(err, ErrUnauthorized):
+		status = 401
+	case errors.Is(err, ErrBodyTooLarge):
+		status = 413
+	case errors.Is(err, ErrInvalidUUID), errors.Is(err, ErrInvalidMessage), errors.Is(err, ErrDecryptFailed), errors.Is(err, ErrNotEncrypted):
+		status = 400
+	case errors.Is(err, ErrMaxPeers):
+		status = 503
+		m.writeRetryAfter(c)
+	case errors.Is(err, ErrMaxPeersPerIP):
+		status = 429
+	}
+
+	c.JSON(status, gin.H{
+		"error": err.Error(),
+	})
+	m.notifyReject(uuid, c.ClientIP(), err)
+}
+
+// Emits a rejection event and calls the reject callback
+func (m *Manager) notifyReject(uuid string, ipAddr string, err error) {
+	m.emit(Event{Type: EventRequestRejected, PeerUUID: uuid, IPAddr: ipAddr, Err: err})
+	if m.RejectCallback != nil {
+		go m.RejectCallback(uuid, ipAddr, err)
+	}
+}
+
+// Parses a list of IPs or CIDRs
+func parseNetworks(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		// Treat plain IPs as single address networks
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, errors.New("invalid IP address: " + s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.New("invalid CIDR: " + s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
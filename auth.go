@@ -0,0 +1,181 @@
+package longpoll
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SessionHeader Header carrying session tokens issued by a SessionIssuer
+const SessionHeader = "session"
+
+var (
+	ErrUnauthorized = errors.New("unauthorized") // Missing or invalid credentials (401)
+	ErrForbidden    = errors.New("forbidden")    // Credentials do not belong to the claimed uuid (403)
+)
+
+// Authenticator Maps a request to a verified peer identity
+type Authenticator interface {
+	// Authenticate Returns the verified peer UUID for a request claiming to be claimedUUID.
+	// known reports whether a peer with that UUID currently exists
+	Authenticate(r *http.Request, claimedUUID string, known bool) (string, error)
+}
+
+// SessionIssuer Implemented by Authenticators that issue session tokens to peers.
+// Tokens are sent in the SessionHeader of every response to an authenticated peer
+type SessionIssuer interface {
+	IssueSession(peerUUID string) (string, error)
+}
+
+// TokenAuthenticator Authenticates peers by an "Authorization: Bearer <token>" header
+type TokenAuthenticator struct {
+	Tokens map[string]string // Map of token to peer UUID
+}
+
+// Authenticate Implements Authenticator
+func (a *TokenAuthenticator) Authenticate(r *http.Request, claimedUUID string, known bool) (string, error) {
+	// Get the token
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return "", ErrUnauthorized
+	}
+
+	// Look up the peer
+	peerUUID, exists := a.Tokens[token]
+	if !exists {
+		return "", ErrUnauthorized
+	}
+	if peerUUID != claimedUUID {
+		return "", ErrForbidden
+	}
+	return peerUUID, nil
+}
+
+// SessionAuthenticator Issues HMAC signed session tokens bound to a peer UUID when the peer is created.
+// Requests for existing peers must present a valid token. Unknown peers are trusted on first use
+type SessionAuthenticator struct {
+	Secret []byte        // Key used to sign tokens (required)
+	TTL    time.Duration // Time before a token expires. Tokens are refreshed on every response
+}
+
+// Authenticate Implements Authenticator
+func (a *SessionAuthenticator) Authenticate(r *http.Request, claimedUUID string, known bool) (string, error) {
+	// Without a secret anyone could sign tokens
+	if len(a.Secret) == 0 {
+		return "", ErrUnauthorized
+	}
+
+	// Get the token
+	token := r.Header.Get(SessionHeader)
+	if token == "" {
+		// New peers receive a token in the creation response
+		if !known {
+			return claimedUUID, nil
+		}
+		return "", ErrUnauthorized
+	}
+
+	// Split the token into uuid, expiry and signature
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrUnauthorized
+	}
+	uuidBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrUnauthorized
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", ErrUnauthorized
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrUnauthorized
+	}
+
+	// Verify the signature and expiry
+	if !hmac.Equal(signature, a.sign(parts[0]+"."+parts[1])) {
+		return "", ErrUnauthorized
+	}
+	if time.Now().Unix() > expiry {
+		return "", ErrUnauthorized
+	}
+
+	// Check the session belongs to the claimed uuid
+	peerUUID := string(uuidBytes)
+	if subtle.ConstantTimeCompare(uuidBytes, []byte(claimedUUID)) != 1 {
+		return "", ErrForbidden
+	}
+	return peerUUID, nil
+}
+
+// IssueSession Implements SessionIssuer
+func (a *SessionAuthenticator) IssueSession(peerUUID string) (string, error) {
+	if len(a.Secret) == 0 {
+		return "", errors.New("session secret is required")
+	}
+	return a.signedToken(peerUUID), nil
+}
+
+// Creates a token for a peer signed with Secret
+func (a *SessionAuthenticator) signedToken(peerUUID string) string {
+	ttl := a.TTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(peerUUID)) + "." + strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(a.sign(payload))
+}
+
+func (a *SessionAuthenticator) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, a.Secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// IdentityAuthenticator Implemented by Authenticators that can identify a peer when the uuid header is empty
+type IdentityAuthenticator interface {
+	Identity(r *http.Request) (string, bool)
+}
+
+// CertificateAuthenticator Authenticates peers by the subject common name of their verified TLS client certificate
+type CertificateAuthenticator struct {
+	UseAsUUID bool // Use the certificate identity as the peer UUID when the uuid header is empty
+}
+
+// Identity Implements IdentityAuthenticator
+func (a *CertificateAuthenticator) Identity(r *http.Request) (string, bool) {
+	if !a.UseAsUUID {
+		return "", false
+	}
+	peerUUID := certificateIdentity(r)
+	return peerUUID, peerUUID != ""
+}
+
+// Authenticate Implements Authenticator
+func (a *CertificateAuthenticator) Authenticate(r *http.Request, claimedUUID string, known bool) (string, error) {
+	// Get the verified certificate identity
+	peerUUID := certificateIdentity(r)
+	if peerUUID == "" {
+		return "", ErrUnauthorized
+	}
+	if peerUUID != claimedUUID {
+		return "", ErrForbidden
+	}
+	return peerUUID, nil
+}
+
+// Returns the subject common name of the verified TLS client certificate, if any
+func certificateIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
@@ -0,0 +1,96 @@
+package longpoll
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSessionAuthenticator(t *testing.T) {
+	a := &SessionAuthenticator{Secret: []byte("secret"), TTL: time.Minute}
+	token, err := a.IssueSession("peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString([]byte("peer")) + "." + strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	expired := payload + "." + base64.RawURLEncoding.EncodeToString(a.sign(payload))
+	forged, err := (&SessionAuthenticator{Secret: []byte("other")}).IssueSession("peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		claimed string
+		known   bool
+		want    error
+	}{
+		{"valid token", token, "peer", true, nil},
+		{"new peer without token", "", "peer", false, nil},
+		{"known peer without token", "", "peer", true, ErrUnauthorized},
+		{"token for another peer", token, "victim", true, ErrForbidden},
+		{"forged token", forged, "peer", true, ErrUnauthorized},
+		{"malformed token", "not.a-token", "peer", true, ErrUnauthorized},
+		{"expired token", expired, "peer", true, ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/poll", nil)
+			if tt.token != "" {
+				r.Header.Set(SessionHeader, tt.token)
+			}
+			_, err := a.Authenticate(r, tt.claimed, tt.known)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionAuthenticatorRequiresSecret(t *testing.T) {
+	// A token signed with an empty key must not be accepted
+	a := &SessionAuthenticator{}
+	forged := (&SessionAuthenticator{TTL: time.Hour}).signedToken("victim")
+	r := httptest.NewRequest("GET", "/poll", nil)
+	r.Header.Set(SessionHeader, forged)
+	_, err := a.Authenticate(r, "victim", true)
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("got %v, want %v", err, ErrUnauthorized)
+	}
+
+	_, err = NewManager(WithAuthenticator(&SessionAuthenticator{}))
+	if err == nil {
+		t.Fatal("NewManager accepted a SessionAuthenticator without a Secret")
+	}
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	a := &TokenAuthenticator{Tokens: map[string]string{"abc": "peer"}}
+	tests := []struct {
+		name    string
+		header  string
+		claimed string
+		want    error
+	}{
+		{"valid token", "Bearer abc", "peer", nil},
+		{"missing header", "", "peer", ErrUnauthorized},
+		{"unknown token", "Bearer xyz", "peer", ErrUnauthorized},
+		{"token for another peer", "Bearer abc", "victim", ErrForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/poll", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			_, err := a.Authenticate(r, tt.claimed, true)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
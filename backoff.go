@@ -0,0 +1,94 @@
+package longpoll
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Longest Retry-After hint a client follows, so a bad hint can't stall a peer for hours
+const maxRetryAfter = 10 * time.Minute
+
+// SetRetryAfter Asks polling clients to wait before polling again eg: while overloaded. The hint is sent as a
+// Retry-After header on 204 and 503 replies until it is set back to 0
+func (m *Manager) SetRetryAfter(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	m.retryAfter.Store(int64(d))
+}
+
+// RetryAfter Returns the hint set with SetRetryAfter
+func (m *Manager) RetryAfter() time.Duration {
+	return time.Duration(m.retryAfter.Load())
+}
+
+// Adds the Retry-After hint to a reply if one is set
+func (m *Manager) writeRetryAfter(c *gin.Context) {
+	if d := m.RetryAfter(); d > 0 {
+		c.Header("Retry-After", formatRetryAfter(d))
+	}
+}
+
+// Formats a duration as whole seconds, rounding up
+func formatRetryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// Reads the Retry-After hint from a 204, 429 or 503 reply. Returns 0 if there is none
+func parseRetryAfter(resp *http.Response) time.Duration {
+	switch resp.StatusCode {
+	case 204, 429, 503:
+	default:
+		return 0
+	}
+
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+
+	// Seconds or an HTTP date
+	var d time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		// Cap before converting so large values can't overflow
+		d = time.Duration(min(seconds, int(maxRetryAfter/time.Second)+1)) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		d = time.Until(t)
+	}
+	if d <= 0 {
+		return 0
+	}
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	return d
+}
+
+// Adds up to 50% random jitter to a delay so many clients don't retry together
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// Waits before the next poll of a server peer. Returns false if the manager stopped or the peer was removed
+func (p *Peer) waitToPoll(m *Manager, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	select {
+	case <-m.stop:
+		return false
+	case <-p.removed:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
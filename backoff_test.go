@@ -0,0 +1,74 @@
+package longpoll
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		value  string
+		min    time.Duration
+		max    time.Duration
+	}{
+		{"seconds", 503, "30", 30 * time.Second, 30 * time.Second},
+		{"seconds with spaces", 204, " 5 ", 5 * time.Second, 5 * time.Second},
+		{"seconds on 429", 429, "1", time.Second, time.Second},
+		{"capped", 503, "86400", maxRetryAfter, maxRetryAfter},
+		{"overflowing seconds capped", 503, "9300000000", maxRetryAfter, maxRetryAfter},
+		{"HTTP date", 503, time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+		{"HTTP date in the past", 503, time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+		{"HTTP date capped", 503, time.Now().Add(24 * time.Hour).UTC().Format(http.TimeFormat), maxRetryAfter, maxRetryAfter},
+		{"missing", 503, "", 0, 0},
+		{"zero", 503, "0", 0, 0},
+		{"negative", 503, "-10", 0, 0},
+		{"fraction", 503, "1.5", 0, 0},
+		{"invalid", 503, "soon", 0, 0},
+		{"ignored on 200", 200, "30", 0, 0},
+		{"ignored on 500", 500, "30", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.value != "" {
+				resp.Header.Set("Retry-After", tt.value)
+			}
+			got := parseRetryAfter(resp)
+			if got < tt.min || got > tt.max {
+				t.Fatalf("got %s, want between %s and %s", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestFormatRetryAfter(t *testing.T) {
+	for d, want := range map[time.Duration]string{time.Second: "1", 1500 * time.Millisecond: "2", time.Minute: "60"} {
+		if got := formatRetryAfter(d); got != want {
+			t.Errorf("%s: got %s, want %s", d, got, want)
+		}
+	}
+}
+
+func TestJitterBounds(t *testing.T) {
+	for _, d := range []time.Duration{-time.Second, 0, 1, time.Millisecond, time.Second, time.Minute} {
+		t.Run(strconv.FormatInt(int64(d), 10), func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				got := jitter(d)
+				if d <= 0 {
+					if got != 0 {
+						t.Fatalf("got %s, want 0", got)
+					}
+					continue
+				}
+				// Between the delay and the delay plus half of it
+				if got < d || got > d+d/2 {
+					t.Fatalf("got %s, want between %s and %s", got, d, d+d/2)
+				}
+			}
+		})
+	}
+}
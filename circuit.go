@@ -0,0 +1,164 @@
+package longpoll
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// CircuitState The state of a server peer's circuit breaker
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"    // Requests are sent
+	CircuitOpen     CircuitState = "open"      // Requests fail fast with a *CircuitOpenError
+	CircuitHalfOpen CircuitState = "half_open" // One request probes the server while the rest fail fast, its result closes or reopens
+)
+
+// ErrCircuitOpen Matches a *CircuitOpenError with errors.Is
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitOpenError Returned instead of sending a request to a server peer whose circuit breaker is open
+type CircuitOpenError struct {
+	PeerUUID string
+	RetryAt  time.Time // When the breaker lets a request through again
+	Err      error     // The failure that opened the breaker
+}
+
+func (e *CircuitOpenError) Error() string {
+	s := "circuit breaker open for " + e.PeerUUID + " until " + e.RetryAt.Format(time.RFC3339)
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// How long to wait before retrying a request that failed fast because a half-open probe was in flight
+const probeRetryDelay = time.Second
+
+// Opens after CircuitBreakerThreshold consecutive failed requests to a server peer, half-opens after
+// CircuitBreakerTimeout
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	lastErr  error
+	probing  bool // A half-open probe is in flight
+}
+
+// Checks whether a request may be sent, moving an open breaker to half-open once its timeout has passed.
+// A half-open breaker lets a single probe through. probe reports whether the request took the probe slot, and
+// must be passed to recordRequest with the result
+func (p *Peer) allowRequest(m *Manager) (probe bool, err error) {
+	threshold, timeout := m.circuitBreakerSettings()
+	if threshold == 0 {
+		return false, nil
+	}
+
+	b := &p.breaker
+	b.mu.Lock()
+	switch b.state {
+	case CircuitOpen:
+		retryAt := b.openedAt.Add(timeout)
+		if time.Now().Before(retryAt) {
+			err := &CircuitOpenError{PeerUUID: p.UUID, RetryAt: retryAt, Err: b.lastErr}
+			b.mu.Unlock()
+			return false, err
+		}
+	case CircuitHalfOpen:
+		// Fail fast until the probe in flight closes or reopens the breaker
+		if b.probing {
+			err := &CircuitOpenError{PeerUUID: p.UUID, RetryAt: time.Now().Add(probeRetryDelay), Err: b.lastErr}
+			b.mu.Unlock()
+			return false, err
+		}
+		b.probing = true
+		b.mu.Unlock()
+		return true, nil
+	default:
+		b.mu.Unlock()
+		return false, nil
+	}
+	b.state = CircuitHalfOpen
+	b.probing = true
+	b.mu.Unlock()
+
+	m.circuitChanged(p.UUID, CircuitHalfOpen, nil)
+	return true, nil
+}
+
+// Records the result of a request, opening the breaker after too many consecutive failures. Only the probe
+// closes or reopens a half-open breaker. Results of requests sent before the breaker opened are ignored once
+// it has
+func (p *Peer) recordRequest(m *Manager, probe bool, err error) {
+	threshold, _ := m.circuitBreakerSettings()
+
+	b := &p.breaker
+	b.mu.Lock()
+	previous := b.circuitState()
+	if probe {
+		b.probing = false
+	}
+	switch {
+	case previous != CircuitClosed && !probe:
+		// A request sent before the breaker opened
+	case err == nil:
+		b.failures = 0
+		b.lastErr = nil
+		b.state = CircuitClosed
+	default:
+		b.failures++
+		b.lastErr = err
+		if threshold > 0 && (probe || b.failures >= threshold) {
+			b.state = CircuitOpen
+			b.openedAt = time.Now()
+		}
+	}
+	state := b.circuitState()
+	b.mu.Unlock()
+
+	if state != previous {
+		m.circuitChanged(p.UUID, state, err)
+	}
+}
+
+// Returns the state of a server peer's circuit breaker
+func (p *Peer) circuitState() CircuitState {
+	p.breaker.mu.Lock()
+	defer p.breaker.mu.Unlock()
+	return p.breaker.circuitState()
+}
+
+// Returns the state, closed if unset. Must be called with mu held
+func (b *circuitBreaker) circuitState() CircuitState {
+	if b.state == "" {
+		return CircuitClosed
+	}
+	return b.state
+}
+
+// Reports a circuit breaker state change
+func (m *Manager) circuitChanged(peerUUID string, state CircuitState, err error) {
+	m.metrics.add("longpoll_circuit_transitions_total", metricLabels("peer", peerUUID, "state", string(state)), 1)
+	m.emit(Event{Type: EventCircuitChanged, PeerUUID: peerUUID, Reason: string(state), Err: err})
+}
+
+// Returns the message_dropped reason for a failed POST
+func postFailedReason(err error) string {
+	if errors.Is(err, ErrCircuitOpen) {
+		return "circuit_open"
+	}
+	return "post_failed"
+}
+
+// Returns CircuitBreakerThreshold and CircuitBreakerTimeout, which Reload may change
+func (m *Manager) circuitBreakerSettings() (int, time.Duration) {
+	m.settingsMU.RLock()
+	defer m.settingsMU.RUnlock()
+	return m.CircuitBreakerThreshold, m.CircuitBreakerTimeout
+}
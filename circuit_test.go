@@ -0,0 +1,116 @@
+package longpoll
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var errRefused = errors.New("connection refused")
+
+// Returns a server peer whose breaker opened after two failures
+func openBreaker(t *testing.T, m *Manager) *Peer {
+	t.Helper()
+	p := &Peer{UUID: "server"}
+	for i := 0; i < 2; i++ {
+		probe, err := p.allowRequest(m)
+		if err != nil || probe {
+			t.Fatalf("closed: probe %v, %v", probe, err)
+		}
+		p.recordRequest(m, probe, errRefused)
+	}
+	if state := p.circuitState(); state != CircuitOpen {
+		t.Fatalf("got %s, want %s", state, CircuitOpen)
+	}
+	return p
+}
+
+func TestCircuitBreakerHalfOpenProbe(t *testing.T) {
+	m, err := NewManager(WithCircuitBreaker(2, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := openBreaker(t, m)
+	if _, err := p.allowRequest(m); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("open: got %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// Only one probe is let through once half-open
+	time.Sleep(20 * time.Millisecond)
+	probe, err := p.allowRequest(m)
+	if err != nil || !probe {
+		t.Fatalf("probe: %v, %v", probe, err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := p.allowRequest(m); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("during probe: got %v, want %v", err, ErrCircuitOpen)
+		}
+	}
+
+	// A failed probe reopens
+	p.recordRequest(m, probe, errRefused)
+	if state := p.circuitState(); state != CircuitOpen {
+		t.Fatalf("got %s, want %s", state, CircuitOpen)
+	}
+
+	// A successful probe closes
+	time.Sleep(20 * time.Millisecond)
+	probe, err = p.allowRequest(m)
+	if err != nil || !probe {
+		t.Fatalf("probe: %v, %v", probe, err)
+	}
+	p.recordRequest(m, probe, nil)
+	if state := p.circuitState(); state != CircuitClosed {
+		t.Fatalf("got %s, want %s", state, CircuitClosed)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := p.allowRequest(m); err != nil {
+			t.Fatalf("closed: %v", err)
+		}
+	}
+}
+
+func TestCircuitBreakerStaleResults(t *testing.T) {
+	m, err := NewManager(WithCircuitBreaker(2, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A slow request sent while closed
+	p := &Peer{UUID: "server"}
+	slow, err := p.allowRequest(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		probe, _ := p.allowRequest(m)
+		p.recordRequest(m, probe, errRefused)
+	}
+
+	// Its success doesn't close the open breaker
+	p.recordRequest(m, slow, nil)
+	if state := p.circuitState(); state != CircuitOpen {
+		t.Fatalf("after stale success got %s, want %s", state, CircuitOpen)
+	}
+
+	// Nor does it end the probe or decide the half-open breaker
+	time.Sleep(20 * time.Millisecond)
+	probe, err := p.allowRequest(m)
+	if err != nil || !probe {
+		t.Fatalf("probe: %v, %v", probe, err)
+	}
+	p.recordRequest(m, slow, nil)
+	p.recordRequest(m, slow, errRefused)
+	if state := p.circuitState(); state != CircuitHalfOpen {
+		t.Fatalf("after stale results got %s, want %s", state, CircuitHalfOpen)
+	}
+	if _, err := p.allowRequest(m); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second probe let through: %v", err)
+	}
+
+	// The probe's result decides
+	p.recordRequest(m, probe, nil)
+	if state := p.circuitState(); state != CircuitClosed {
+		t.Fatalf("got %s, want %s", state, CircuitClosed)
+	}
+}
@@ -0,0 +1,727 @@
+package longpoll
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigEnvPrefix Prefix of environment variables that override top level config keys eg: LONGPOLL_POLL_LENGTH=30s
+const ConfigEnvPrefix = "LONGPOLL_"
+
+// Config Manager settings loaded from a YAML, JSON or TOML file (see LoadConfig).
+// Keys are snake_case versions of the field names eg: poll_length. Durations are strings like "30s" or numbers of seconds
+type Config struct {
+	UUID                    string
+	Port                    int
+	Path                    string
+	PollLength              time.Duration
+	MinPollLength           time.Duration
+	MaxPollLength           time.Duration
+	PeerExpiry              time.Duration
+	Deadline                time.Duration
+	OutboundBufferSize      int
+	CircuitBreakerThreshold int
+	CircuitBreakerTimeout   time.Duration
+	MaxPeers                int
+	MaxPeersPerIP           int
+	AllowedIPs              []string
+	DeniedIPs               []string
+	TLSCertFile             string
+	TLSKeyFile              string
+	TLSClientCAFile         string
+	MetricsPath             string
+	AdminPath               string
+	AdminPort               int
+	HealthChecks            bool
+	Transport               *TransportConfig // Keys: proxy_url, dial_timeout, keep_alive, tls_handshake_timeout, max_idle_conns, max_idle_conns_per_host, max_conns_per_host, idle_conn_timeout
+	ServerPeers             []ServerPeerEntry
+}
+
+// ServerPeerEntry A server peer in a Config
+type ServerPeerEntry struct {
+	UUID             string
+	URL              string
+	URLs             []string         // More URLs of the same server to fail over or balance across
+	Strategy         EndpointStrategy // failover, round_robin or random
+	Headers          map[string]string
+	StickyAttributes map[string]string
+	Topics           []string // Initial topic subscriptions
+	Required         bool
+	PollDuration     time.Duration    // Poll duration to request from the server
+	TLS              *ClientTLSConfig // Keys: ca_file, cert_file, key_file, server_name, pinned_sha256, insecure_skip_verify
+	Transport        *TransportConfig // Same keys as the top level transport, merged over it
+	RedirectHosts    []string         // Hosts a draining server may redirect to
+}
+
+// ConfigError A config value that could not be parsed or is invalid. Key is the path to the value eg: server_peers[1].url
+type ConfigError struct {
+	Key string
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return "config: " + e.Key + ": " + e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultConfig Returns a Config with the same settings as NewDefaultManager
+func DefaultConfig() *Config {
+	m := NewDefaultManager()
+	return &Config{
+		Port:               m.API_Port,
+		Path:               m.API_Path,
+		PollLength:         m.PollLength,
+		MinPollLength:      m.MinPollLength,
+		MaxPollLength:      m.MaxPollLength,
+		PeerExpiry:         m.PeerExpiry,
+		Deadline:           m.Deadline,
+		OutboundBufferSize: m.OutboundBufferSize,
+
+		CircuitBreakerThreshold: m.CircuitBreakerThreshold,
+		CircuitBreakerTimeout:   m.CircuitBreakerTimeout,
+	}
+}
+
+// LoadConfig Reads a config file, applies environment overrides (see ConfigEnvPrefix) and validates it.
+// The format is chosen by the file extension: .yaml, .yml, .json or .toml
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("failed to read config: " + err.Error())
+	}
+
+	format := strings.TrimPrefix(filepath.Ext(path), ".")
+	return ParseConfig(data, format, os.Environ())
+}
+
+// ParseConfig Parses a config in the given format (yaml, json or toml), applies environment overrides from
+// env (KEY=value pairs, see ConfigEnvPrefix) and validates it. Unset keys keep the DefaultConfig values
+func ParseConfig(data []byte, format string, env []string) (*Config, error) {
+	// Decode the document
+	raw := make(map[string]interface{})
+	var err error
+	switch strings.ToLower(format) {
+	case "yaml", "yml":
+		err = yaml.Unmarshal(data, &raw)
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&raw)
+	case "toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, errors.New("unsupported config format: " + format)
+	}
+	if err != nil {
+		return nil, errors.New("failed to parse config: " + err.Error())
+	}
+	if raw == nil {
+		raw = make(map[string]interface{})
+	}
+
+	// Apply environment overrides
+	sources := make(map[string]string)
+	for _, kv := range env {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, ConfigEnvPrefix) {
+			continue
+		}
+		key := strings.ToLower(strings.TrimPrefix(name, ConfigEnvPrefix))
+		if _, ok := configFields[key]; !ok {
+			// Not a config key eg: LONGPOLL_CONFIG naming the file itself
+			continue
+		}
+		raw[key] = value
+		sources[key] = name
+	}
+
+	// Decode into the config
+	cfg := DefaultConfig()
+	err = cfg.decode(raw)
+	if err != nil {
+		var configErr *ConfigError
+		if errors.As(err, &configErr) {
+			top, _, _ := strings.Cut(configErr.Key, "[")
+			top, _, _ = strings.Cut(top, ".")
+			if name, ok := sources[top]; ok {
+				configErr.Key += " (from " + name + ")"
+			}
+		}
+		return nil, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate Checks the settings are valid, returning a *ConfigError naming the offending key
+func (c *Config) Validate() error {
+	if c.Port < 0 || c.Port > 65535 {
+		return &ConfigError{Key: "port", Err: errors.New("must be between 0 and 65535")}
+	}
+	if !strings.HasPrefix(c.Path, "/") {
+		return &ConfigError{Key: "path", Err: errors.New("must start with /")}
+	}
+	if c.PollLength < time.Second {
+		return &ConfigError{Key: "poll_length", Err: errors.New("must be at least 1 second")}
+	}
+	if c.MinPollLength < 0 {
+		return &ConfigError{Key: "min_poll_length", Err: errors.New("must not be negative")}
+	}
+	if c.MaxPollLength < 0 || (c.MaxPollLength > 0 && c.MaxPollLength < c.MinPollLength) {
+		return &ConfigError{Key: "max_poll_length", Err: errors.New("must not be less than min_poll_length")}
+	}
+	if c.PeerExpiry < time.Second {
+		return &ConfigError{Key: "peer_expiry", Err: errors.New("must be at least 1 second")}
+	}
+	if c.Deadline < time.Second {
+		return &ConfigError{Key: "deadline", Err: errors.New("must be at least 1 second")}
+	}
+	if c.OutboundBufferSize < 0 {
+		return &ConfigError{Key: "outbound_buffer_size", Err: errors.New("must not be negative")}
+	}
+	if c.CircuitBreakerThreshold < 0 {
+		return &ConfigError{Key: "circuit_breaker_threshold", Err: errors.New("must not be negative")}
+	}
+	if c.CircuitBreakerThreshold > 0 && c.CircuitBreakerTimeout <= 0 {
+		return &ConfigError{Key: "circuit_breaker_timeout", Err: errors.New("must be positive")}
+	}
+	if c.MaxPeers < 0 {
+		return &ConfigError{Key: "max_peers", Err: errors.New("must not be negative")}
+	}
+	if c.MaxPeersPerIP < 0 {
+		return &ConfigError{Key: "max_peers_per_ip", Err: errors.New("must not be negative")}
+	}
+	if _, err := parseNetworks(c.AllowedIPs); err != nil {
+		return &ConfigError{Key: "allowed_ips", Err: err}
+	}
+	if _, err := parseNetworks(c.DeniedIPs); err != nil {
+		return &ConfigError{Key: "denied_ips", Err: err}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return &ConfigError{Key: "tls_cert_file", Err: errors.New("tls_cert_file and tls_key_file must be set together")}
+	}
+	if c.MetricsPath != "" && !strings.HasPrefix(c.MetricsPath, "/") {
+		return &ConfigError{Key: "metrics_path", Err: errors.New("must start with /")}
+	}
+	if c.AdminPath != "" && !strings.HasPrefix(c.AdminPath, "/") {
+		return &ConfigError{Key: "admin_path", Err: errors.New("must start with /")}
+	}
+	if c.AdminPort < 0 || c.AdminPort > 65535 {
+		return &ConfigError{Key: "admin_port", Err: errors.New("must be between 0 and 65535")}
+	}
+
+	if c.Transport != nil {
+		if err := c.Transport.Validate(); err != nil {
+			return &ConfigError{Key: "transport", Err: err}
+		}
+	}
+
+	// Check server peers
+	seen := make(map[string]bool)
+	for i, peer := range c.ServerPeers {
+		key := "server_peers[" + strconv.Itoa(i) + "]"
+		if peer.UUID == "" {
+			return &ConfigError{Key: key + ".uuid", Err: errors.New("is required")}
+		}
+		if seen[peer.UUID] {
+			return &ConfigError{Key: key + ".uuid", Err: errors.New("duplicate server peer " + peer.UUID)}
+		}
+		seen[peer.UUID] = true
+
+		if peer.URL == "" && len(peer.URLs) == 0 {
+			return &ConfigError{Key: key + ".url", Err: errors.New("is required")}
+		}
+		if peer.URL != "" && !isHTTPURL(peer.URL) {
+			return &ConfigError{Key: key + ".url", Err: errors.New("must be an http or https URL")}
+		}
+		for j, u := range peer.URLs {
+			if !isHTTPURL(u) {
+				return &ConfigError{Key: key + ".urls[" + strconv.Itoa(j) + "]", Err: errors.New("must be an http or https URL")}
+			}
+		}
+		err := peer.Strategy.Validate()
+		if err != nil {
+			return &ConfigError{Key: key + ".strategy", Err: err}
+		}
+		if peer.Transport != nil {
+			if err := peer.Transport.Validate(); err != nil {
+				return &ConfigError{Key: key + ".transport", Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// Options Returns the options that apply the config to a Manager
+func (c *Config) Options() []Option {
+	return []Option{
+		func(m *Manager) error {
+			if c.UUID != "" {
+				m.UUID = c.UUID
+			}
+			m.API_Port = c.Port
+			m.API_Path = c.Path
+			m.PollLength = c.PollLength
+			m.MinPollLength = c.MinPollLength
+			m.MaxPollLength = c.MaxPollLength
+			m.PeerExpiry = c.PeerExpiry
+			m.Deadline = c.Deadline
+			m.OutboundBufferSize = c.OutboundBufferSize
+			m.CircuitBreakerThreshold = c.CircuitBreakerThreshold
+			m.CircuitBreakerTimeout = c.CircuitBreakerTimeout
+			m.MaxPeers = c.MaxPeers
+			m.MaxPeersPerIP = c.MaxPeersPerIP
+			m.AllowedIPs = c.AllowedIPs
+			m.DeniedIPs = c.DeniedIPs
+			m.TLSCertFile = c.TLSCertFile
+			m.TLSKeyFile = c.TLSKeyFile
+			m.TLSClientCAFile = c.TLSClientCAFile
+			m.MetricsPath = c.MetricsPath
+			m.AdminPath = c.AdminPath
+			m.AdminPort = c.AdminPort
+			m.HealthChecks = c.HealthChecks
+			m.TransportConfig = c.Transport
+			return nil
+		},
+	}
+}
+
+// NewManagerFromConfig Creates a Manager from a config, applying opts after it eg: callbacks.
+// Server peers in the config are added and start polling straight away
+func NewManagerFromConfig(cfg *Config, opts ...Option) (*Manager, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := NewManager(append(cfg.Options(), opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	m.config = cfg
+
+	// Add server peers
+	for i, entry := range cfg.ServerPeers {
+		err := m.addServerPeerEntry(entry)
+		if err != nil {
+			m.Stop()
+			return nil, &ConfigError{Key: "server_peers[" + strconv.Itoa(i) + "]", Err: err}
+		}
+	}
+	return m, nil
+}
+
+// Adds a server peer from a config entry with its initial topics
+func (m *Manager) addServerPeerEntry(entry ServerPeerEntry) error {
+	err := m.AddServerPeerWithConfig(entry.UUID, entry.config())
+	if err != nil {
+		return err
+	}
+	if len(entry.Topics) > 0 {
+		err = m.SetTopics(entry.UUID, entry.Topics)
+		if err != nil {
+			m.DeletePeer(entry.UUID)
+			return err
+		}
+	}
+	return nil
+}
+
+// Converts a config entry to the settings for AddServerPeerWithConfig
+func (e ServerPeerEntry) config() ServerPeerConfig {
+	return ServerPeerConfig{
+		URL:              e.URL,
+		URLs:             e.URLs,
+		Strategy:         e.Strategy,
+		Headers:          e.Headers,
+		StickyAttributes: e.StickyAttributes,
+		TLS:              e.TLS,
+		Transport:        e.Transport,
+		Required:         e.Required,
+		PollDuration:     e.PollDuration,
+		RedirectHosts:    e.RedirectHosts,
+	}
+}
+
+// Decoders for the top level keys. Environment variables for other keys are ignored
+var configFields = map[string]func(c *Config, value interface{}) error{
+	"uuid":                      configField(configString, func(c *Config) *string { return &c.UUID }),
+	"port":                      configField(configInt, func(c *Config) *int { return &c.Port }),
+	"path":                      configField(configString, func(c *Config) *string { return &c.Path }),
+	"poll_length":               configField(configDuration, func(c *Config) *time.Duration { return &c.PollLength }),
+	"min_poll_length":           configField(configDuration, func(c *Config) *time.Duration { return &c.MinPollLength }),
+	"max_poll_length":           configField(configDuration, func(c *Config) *time.Duration { return &c.MaxPollLength }),
+	"peer_expiry":               configField(configDuration, func(c *Config) *time.Duration { return &c.PeerExpiry }),
+	"deadline":                  configField(configDuration, func(c *Config) *time.Duration { return &c.Deadline }),
+	"outbound_buffer_size":      configField(configInt, func(c *Config) *int { return &c.OutboundBufferSize }),
+	"circuit_breaker_threshold": configField(configInt, func(c *Config) *int { return &c.CircuitBreakerThreshold }),
+	"circuit_breaker_timeout":   configField(configDuration, func(c *Config) *time.Duration { return &c.CircuitBreakerTimeout }),
+	"max_peers":                 configField(configInt, func(c *Config) *int { return &c.MaxPeers }),
+	"max_peers_per_ip":          configField(configInt, func(c *Config) *int { return &c.MaxPeersPerIP }),
+	"allowed_ips":               configField(configStrings, func(c *Config) *[]string { return &c.AllowedIPs }),
+	"denied_ips":                configField(configStrings, func(c *Config) *[]string { return &c.DeniedIPs }),
+	"tls_cert_file":             configField(configString, func(c *Config) *string { return &c.TLSCertFile }),
+	"tls_key_file":              configField(configString, func(c *Config) *string { return &c.TLSKeyFile }),
+	"tls_client_ca_file":        configField(configString, func(c *Config) *string { return &c.TLSClientCAFile }),
+	"metrics_path":              configField(configString, func(c *Config) *string { return &c.MetricsPath }),
+	"admin_path":                configField(configString, func(c *Config) *string { return &c.AdminPath }),
+	"admin_port":                configField(configInt, func(c *Config) *int { return &c.AdminPort }),
+	"health_checks":             configField(configBool, func(c *Config) *bool { return &c.HealthChecks }),
+	"transport":                 configField(decodeTransport, func(c *Config) **TransportConfig { return &c.Transport }),
+	"server_peers":              configField(decodeServerPeers, func(c *Config) *[]ServerPeerEntry { return &c.ServerPeers }),
+}
+
+// Returns a decoder that stores the value decoded by decode in the field returned by field
+func configField[T any](decode func(interface{}) (T, error), field func(c *Config) *T) func(c *Config, value interface{}) error {
+	return func(c *Config, value interface{}) error {
+		v, err := decode(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = v
+		return nil
+	}
+}
+
+// Decodes the top level keys of a parsed document
+func (c *Config) decode(raw map[string]interface{}) error {
+	for _, key := range sortedKeys(raw) {
+		err := errors.New("unknown key")
+		if decodeField, ok := configFields[key]; ok {
+			err = decodeField(c, raw[key])
+		}
+		if err != nil {
+			return nestConfigError(key, err)
+		}
+	}
+	return nil
+}
+
+// Decodes the server_peers list
+func decodeServerPeers(value interface{}) ([]ServerPeerEntry, error) {
+	// Environment overrides are JSON
+	if s, ok := value.(string); ok {
+		decoder := json.NewDecoder(strings.NewReader(s))
+		decoder.UseNumber()
+		err := decoder.Decode(&value)
+		if err != nil {
+			return nil, errors.New("must be a JSON list: " + err.Error())
+		}
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("must be a list")
+	}
+
+	entries := make([]ServerPeerEntry, 0, len(list))
+	for i, item := range list {
+		index := "[" + strconv.Itoa(i) + "]"
+		raw, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, &ConfigError{Key: index, Err: errors.New("must be a table")}
+		}
+
+		var entry ServerPeerEntry
+		for _, key := range sortedKeys(raw) {
+			value := raw[key]
+			var err error
+			switch key {
+			case "uuid":
+				entry.UUID, err = configString(value)
+			case "url":
+				entry.URL, err = configString(value)
+			case "urls":
+				entry.URLs, err = configStrings(value)
+			case "strategy":
+				var strategy string
+				strategy, err = configString(value)
+				entry.Strategy = EndpointStrategy(strategy)
+			case "headers":
+				entry.Headers, err = configStringMap(value)
+			case "sticky_attributes":
+				entry.StickyAttributes, err = configStringMap(value)
+			case "topics":
+				entry.Topics, err = configStrings(value)
+			case "required":
+				entry.Required, err = configBool(value)
+			case "poll_duration":
+				entry.PollDuration, err = configDuration(value)
+			case "tls":
+				entry.TLS, err = decodeClientTLS(value)
+			case "transport":
+				entry.Transport, err = decodeTransport(value)
+			case "redirect_hosts":
+				entry.RedirectHosts, err = configStrings(value)
+			default:
+				err = errors.New("unknown key")
+			}
+			if err != nil {
+				return nil, nestConfigError(index+"."+key, err)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Decodes the tls table of a server peer
+func decodeClientTLS(value interface{}) (*ClientTLSConfig, error) {
+	raw, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("must be a table")
+	}
+
+	config := &ClientTLSConfig{}
+	for _, key := range sortedKeys(raw) {
+		value := raw[key]
+		var err error
+		switch key {
+		case "ca_file":
+			config.CAFile, err = configString(value)
+		case "cert_file":
+			config.CertFile, err = configString(value)
+		case "key_file":
+			config.KeyFile, err = configString(value)
+		case "server_name":
+			config.ServerName, err = configString(value)
+		case "pinned_sha256":
+			config.PinnedSHA256, err = configStrings(value)
+		case "insecure_skip_verify":
+			config.InsecureSkipVerify, err = configBool(value)
+		default:
+			err = errors.New("unknown key")
+		}
+		if err != nil {
+			return nil, nestConfigError(key, err)
+		}
+	}
+	return config, nil
+}
+
+// Decodes a transport table
+func decodeTransport(value interface{}) (*TransportConfig, error) {
+	// Environment overrides are JSON
+	if s, ok := value.(string); ok {
+		decoder := json.NewDecoder(strings.NewReader(s))
+		decoder.UseNumber()
+		err := decoder.Decode(&value)
+		if err != nil {
+			return nil, errors.New("must be a JSON object: " + err.Error())
+		}
+	}
+
+	raw, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("must be a table")
+	}
+
+	config := &TransportConfig{}
+	for _, key := range sortedKeys(raw) {
+		value := raw[key]
+		var err error
+		switch key {
+		case "proxy_url":
+			config.ProxyURL, err = configString(value)
+		case "dial_timeout":
+			config.DialTimeout, err = configDuration(value)
+		case "keep_alive":
+			config.KeepAlive, err = configDuration(value)
+		case "tls_handshake_timeout":
+			config.TLSHandshakeTimeout, err = configDuration(value)
+		case "max_idle_conns":
+			config.MaxIdleConns, err = configInt(value)
+		case "max_idle_conns_per_host":
+			config.MaxIdleConnsPerHost, err = configInt(value)
+		case "max_conns_per_host":
+			config.MaxConnsPerHost, err = configInt(value)
+		case "idle_conn_timeout":
+			config.IdleConnTimeout, err = configDuration(value)
+		default:
+			err = errors.New("unknown key")
+		}
+		if err != nil {
+			return nil, nestConfigError(key, err)
+		}
+	}
+	return config, nil
+}
+
+// Prefixes the key of a nested error, or wraps a plain error with the key
+func nestConfigError(key string, err error) error {
+	var configErr *ConfigError
+	if errors.As(err, &configErr) {
+		if strings.HasPrefix(configErr.Key, "[") {
+			return &ConfigError{Key: key + configErr.Key, Err: configErr.Err}
+		}
+		return &ConfigError{Key: key + "." + configErr.Key, Err: configErr.Err}
+	}
+	return &ConfigError{Key: key, Err: err}
+}
+
+// Returns the keys of a table in a stable order so errors are deterministic
+func sortedKeys(raw map[string]interface{}) []string {
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Checks a string is an absolute http or https URL
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func configString(value interface{}) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", errors.New("must be a string")
+	}
+	return s, nil
+}
+
+func configInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case uint64:
+		if v > math.MaxInt {
+			return 0, errors.New("is too large")
+		}
+		return int(v), nil
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, errors.New("must be a whole number")
+		}
+		return int(v), nil
+	case json.Number:
+		return configInt(v.String())
+	case string:
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return 0, errors.New("must be a whole number")
+		}
+		return i, nil
+	}
+	return 0, errors.New("must be a whole number")
+}
+
+func configBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return false, errors.New("must be true or false")
+		}
+		return b, nil
+	}
+	return false, errors.New("must be true or false")
+}
+
+func configDuration(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case string:
+		// Plain numbers are seconds
+		if seconds, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return time.Duration(seconds * float64(time.Second)), nil
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			return 0, errors.New("must be a duration eg: 30s")
+		}
+		return d, nil
+	case int, int64, uint64, float64, json.Number:
+		seconds, err := configFloat(v)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return 0, errors.New("must be a duration eg: 30s")
+}
+
+func configFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case json.Number:
+		return v.Float64()
+	}
+	return 0, errors.New("must be a number")
+}
+
+func configStrings(value interface{}) ([]string, error) {
+	// Environment overrides are comma separated
+	if s, ok := value.(string); ok {
+		if strings.TrimSpace(s) == "" {
+			return nil, nil
+		}
+		list := strings.Split(s, ",")
+		for i := range list {
+			list[i] = strings.TrimSpace(list[i])
+		}
+		return list, nil
+	}
+
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("must be a list of strings")
+	}
+	list := make([]string, 0, len(items))
+	for i, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, &ConfigError{Key: "[" + strconv.Itoa(i) + "]", Err: errors.New("must be a string")}
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+func configStringMap(value interface{}) (map[string]string, error) {
+	raw, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("must be a table of strings")
+	}
+	result := make(map[string]string, len(raw))
+	for key, item := range raw {
+		s, ok := item.(string)
+		if !ok {
+			return nil, &ConfigError{Key: key, Err: errors.New("must be a string")}
+		}
+		result[key] = s
+	}
+	return result, nil
+}
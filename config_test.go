@@ -0,0 +1,53 @@
+package longpoll
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfigEnv(t *testing.T) {
+	env := []string{
+		"LONGPOLL_CONFIG=/etc/longpoll.yaml",
+		"LONGPOLL_PORT=9000",
+		"LONGPOLL_POLL_LENGTH=1m",
+		"PATH=/usr/bin",
+	}
+	cfg, err := ParseConfig([]byte("port: 8080\n"), "yaml", env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 9000 || cfg.PollLength != time.Minute {
+		t.Fatalf("got port %d, poll length %s", cfg.Port, cfg.PollLength)
+	}
+
+	// Invalid values of known keys are still reported
+	_, err = ParseConfig([]byte("{}"), "yaml", []string{"LONGPOLL_PORT=abc"})
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || configErr.Key != "port (from LONGPOLL_PORT)" {
+		t.Fatalf("got %v, want a ConfigError for port", err)
+	}
+
+	// Unknown keys in the file are still rejected
+	_, err = ParseConfig([]byte("config: x\n"), "yaml", nil)
+	if err == nil {
+		t.Fatal("unknown file key accepted")
+	}
+}
+
+func TestConfigEnvKeys(t *testing.T) {
+	// Every key must be settable from the environment: the override either changes the config or is rejected
+	for key := range configFields {
+		name := ConfigEnvPrefix + strings.ToUpper(key)
+		cfg, err := ParseConfig([]byte("{}"), "yaml", []string{name + "=!"})
+		if err != nil {
+			// Rejected values show the override was decoded
+			continue
+		}
+		if reflect.DeepEqual(cfg, DefaultConfig()) {
+			t.Errorf("%s was ignored", name)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package longpoll
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid message signature") // Signature missing or does not verify (403)
+	ErrDecryptFailed    = errors.New("failed to decrypt message") // Encrypted data could not be decrypted (400)
+	ErrNotEncrypted     = errors.New("message is not encrypted")  // Manager.RequireEncryption is set (400)
+)
+
+// KeyStore Provides the keys used to sign, verify, encrypt and decrypt messages, keyed by peer UUID
+type KeyStore interface {
+	// SigningKey Returns the private key this manager signs messages with
+	SigningKey() (ed25519.PrivateKey, error)
+
+	// VerifyKey Returns the public key messages from a peer are verified with
+	VerifyKey(peerUUID string) (ed25519.PublicKey, error)
+
+	// EncryptionKey Returns the 32 byte AES-256 key shared with a peer
+	EncryptionKey(peerUUID string) ([]byte, error)
+}
+
+// StaticKeyStore A KeyStore backed by fixed keys
+type StaticKeyStore struct {
+	PrivateKey ed25519.PrivateKey           // Key this manager signs with
+	PublicKeys map[string]ed25519.PublicKey // Map of peer UUID to the key the peer signs with
+	SharedKeys map[string][]byte            // Map of peer UUID to the AES-256 key shared with the peer
+}
+
+// SigningKey Implements KeyStore
+func (s *StaticKeyStore) SigningKey() (ed25519.PrivateKey, error) {
+	if len(s.PrivateKey) != ed25519.PrivateKeySize {
+		return nil, errors.New("no signing key")
+	}
+	return s.PrivateKey, nil
+}
+
+// VerifyKey Implements KeyStore
+func (s *StaticKeyStore) VerifyKey(peerUUID string) (ed25519.PublicKey, error) {
+	key, _ := s.PublicKeys[peerUUID]
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("no verify key for peer: " + peerUUID)
+	}
+	return key, nil
+}
+
+// EncryptionKey Implements KeyStore
+func (s *StaticKeyStore) EncryptionKey(peerUUID string) ([]byte, error) {
+	key, _ := s.SharedKeys[peerUUID]
+	if len(key) != 32 {
+		return nil, errors.New("no encryption key for peer: " + peerUUID)
+	}
+	return key, nil
+}
+
+// Encrypts and signs an outgoing message for a peer as configured
+func (m *Manager) sealMessage(peerUUID string, msg Message) (Message, error) {
+	if m.KeyStore == nil || (!m.EncryptMessages && !m.SignMessages) {
+		return msg, nil
+	}
+
+	// Drop any previous seal eg: when forwarding
+	msg.Signature = nil
+	msg.Encrypted = false
+
+	// Encrypt the data
+	if m.EncryptMessages {
+		key, err := m.KeyStore.EncryptionKey(peerUUID)
+		if err != nil {
+			return msg, err
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return msg, err
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		_, err = rand.Read(nonce)
+		if err != nil {
+			return msg, err
+		}
+		msg.Data = gcm.Seal(nonce, nonce, msg.Data, []byte(msg.MessageID))
+		msg.Encrypted = true
+	}
+
+	// Sign the message
+	if m.SignMessages {
+		key, err := m.KeyStore.SigningKey()
+		if err != nil {
+			return msg, err
+		}
+		msg.Signature = ed25519.Sign(key, signingPayload(msg))
+	}
+	return msg, nil
+}
+
+// Verifies and decrypts an incoming message from a peer as configured
+func (m *Manager) openMessage(peerUUID string, msg Message) (Message, error) {
+	// Verify the signature
+	if len(msg.Signature) > 0 && m.KeyStore != nil {
+		key, err := m.KeyStore.VerifyKey(peerUUID)
+		if err != nil {
+			return msg, errors.Join(ErrInvalidSignature, err)
+		}
+		if !ed25519.Verify(key, signingPayload(msg), msg.Signature) {
+			return msg, ErrInvalidSignature
+		}
+	} else if m.RequireSignatures {
+		return msg, ErrInvalidSignature
+	}
+
+	// Decrypt the data
+	if msg.Encrypted {
+		if m.KeyStore == nil {
+			return msg, ErrDecryptFailed
+		}
+		key, err := m.KeyStore.EncryptionKey(peerUUID)
+		if err != nil {
+			return msg, errors.Join(ErrDecryptFailed, err)
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return msg, errors.Join(ErrDecryptFailed, err)
+		}
+		if len(msg.Data) < gcm.NonceSize() {
+			return msg, ErrDecryptFailed
+		}
+		nonce, ciphertext := msg.Data[:gcm.NonceSize()], msg.Data[gcm.NonceSize():]
+		data, err := gcm.Open(nil, nonce, ciphertext, []byte(msg.MessageID))
+		if err != nil {
+			return msg, ErrDecryptFailed
+		}
+		msg.Data = data
+		msg.Encrypted = false
+	} else if m.RequireEncryption {
+		return msg, ErrNotEncrypted
+	}
+	return msg, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Builds the bytes covered by a message signature. Every field is length prefixed so fields can't be shifted
+func signingPayload(msg Message) []byte {
+	var payload []byte
+	field := func(b []byte) {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(b)))
+		payload = append(payload, b...)
+	}
+
+	field([]byte("longpoll-v1"))
+	field([]byte(msg.MessageID))
+	field([]byte(msg.PublishTime.UTC().Format(time.RFC3339Nano)))
+	if msg.Encrypted {
+		field([]byte{1})
+	} else {
+		field([]byte{0})
+	}
+
+	// Attributes in key order
+	keys := make([]string, 0, len(msg.Attributes))
+	for k := range msg.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(len(keys)))
+	for _, k := range keys {
+		field([]byte(k))
+		field([]byte(msg.Attributes[k]))
+	}
+
+	field(msg.Data)
+	return payload
+}
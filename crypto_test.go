@@ -0,0 +1,151 @@
+package longpoll
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Returns managers a and b that sign and encrypt messages to each other
+func cryptoPair(t *testing.T) (*Manager, *Manager) {
+	t.Helper()
+	publicA, privateA, _ := ed25519.GenerateKey(nil)
+	publicB, privateB, _ := ed25519.GenerateKey(nil)
+	shared := bytes.Repeat([]byte{7}, 32)
+
+	a, err := NewManager(WithKeyStore(&StaticKeyStore{
+		PrivateKey: privateA,
+		PublicKeys: map[string]ed25519.PublicKey{"b": publicB},
+		SharedKeys: map[string][]byte{"b": shared},
+	}), WithSigning(true), WithEncryption(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewManager(WithKeyStore(&StaticKeyStore{
+		PrivateKey: privateB,
+		PublicKeys: map[string]ed25519.PublicKey{"a": publicA},
+		SharedKeys: map[string][]byte{"a": shared},
+	}), WithSigning(true), WithEncryption(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, b
+}
+
+func testMessage() Message {
+	return Message{
+		Data:        []byte(`"hello"`),
+		Attributes:  map[string]string{"topic": "news"},
+		MessageID:   "1",
+		PublishTime: time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+	}
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	a, b := cryptoPair(t)
+	sealed, err := a.sealMessage("b", testMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sealed.Encrypted || len(sealed.Signature) == 0 || bytes.Contains(sealed.Data, []byte("hello")) {
+		t.Fatalf("message not sealed: %+v", sealed)
+	}
+
+	opened, err := b.openMessage("a", sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(opened.Data) != `"hello"` || opened.Encrypted {
+		t.Fatalf("got %+v", opened)
+	}
+}
+
+func TestOpenRejectsTampering(t *testing.T) {
+	a, b := cryptoPair(t)
+	tests := []struct {
+		name   string
+		tamper func(*Message)
+	}{
+		{"attribute changed", func(msg *Message) { msg.Attributes["topic"] = "admin" }},
+		{"attribute added", func(msg *Message) { msg.Attributes["extra"] = "1" }},
+		{"data changed", func(msg *Message) { msg.Data[len(msg.Data)-1] ^= 1 }},
+		{"publish time changed", func(msg *Message) { msg.PublishTime = msg.PublishTime.Add(time.Second) }},
+		{"message id changed", func(msg *Message) { msg.MessageID = "2" }},
+		{"encrypted flag cleared", func(msg *Message) { msg.Encrypted = false }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := a.sealMessage("b", testMessage())
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(&sealed)
+			_, err = b.openMessage("a", sealed)
+			if err == nil {
+				t.Fatal("tampered message accepted")
+			}
+		})
+	}
+}
+
+func TestOpenRequiresSealing(t *testing.T) {
+	a, b := cryptoPair(t)
+	tests := []struct {
+		name    string
+		sign    bool
+		encrypt bool
+		want    error
+	}{
+		{"unsigned", false, true, ErrInvalidSignature},
+		{"unencrypted", true, false, ErrNotEncrypted},
+		{"plain", false, false, ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.SignMessages = tt.sign
+			a.EncryptMessages = tt.encrypt
+			sealed, err := a.sealMessage("b", testMessage())
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = b.openMessage("a", sealed)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenWrongKeys(t *testing.T) {
+	a, b := cryptoPair(t)
+	sealed, err := a.sealMessage("b", testMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := b.KeyStore.(*StaticKeyStore)
+
+	// Wrong verification key
+	other, _, _ := ed25519.GenerateKey(nil)
+	publicA := keys.PublicKeys["a"]
+	keys.PublicKeys["a"] = other
+	_, err = b.openMessage("a", sealed)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("wrong verify key: got %v, want %v", err, ErrInvalidSignature)
+	}
+	keys.PublicKeys["a"] = publicA
+
+	// Wrong decryption key
+	keys.SharedKeys["a"] = bytes.Repeat([]byte{8}, 32)
+	_, err = b.openMessage("a", sealed)
+	if !errors.Is(err, ErrDecryptFailed) {
+		t.Fatalf("wrong decryption key: got %v, want %v", err, ErrDecryptFailed)
+	}
+
+	// No key for the peer
+	_, err = b.openMessage("c", sealed)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("unknown peer: got %v, want %v", err, ErrInvalidSignature)
+	}
+}
@@ -0,0 +1,143 @@
+package longpoll
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrDraining Reported for messages still queued for a client peer when it is redirected by Drain
+var ErrDraining = errors.New("manager is draining")
+
+// Drain Moves client peers to another server for maintenance. New peers are redirected to targetURL straight
+// away. Existing client peers receive their queued messages on their next polls and are then redirected and
+// removed. The manager reports not ready (see ReadyHandler) while draining
+func (m *Manager) Drain(targetURL string) error {
+	if !isHTTPURL(targetURL) {
+		return errors.New("drain target must be an http or https URL")
+	}
+
+	m.settingsMU.Lock()
+	m.drainURL = targetURL
+	m.settingsMU.Unlock()
+
+	m.emit(Event{Type: EventDraining, URL: targetURL})
+	return nil
+}
+
+// Draining Returns the drain target URL and whether the manager is draining
+func (m *Manager) Draining() (string, bool) {
+	m.settingsMU.RLock()
+	defer m.settingsMU.RUnlock()
+	return m.drainURL, m.drainURL != ""
+}
+
+// Flushes a queued message to a client peer of a draining manager, or redirects the peer once its queue is empty.
+// Returns false if the manager isn't draining
+func (m *Manager) handleDrainGET(c *gin.Context, peer *Peer) bool {
+	target, draining := m.Draining()
+	if !draining {
+		return false
+	}
+
+	select {
+	case msg := <-peer.Ch:
+		peer.dequeued()
+		peer.consumed()
+		c.JSON(200, msg)
+	default:
+		m.redirectPeer(c, peer, target)
+	}
+	return true
+}
+
+// Redirects a client peer to the drain target and removes it
+func (m *Manager) redirectPeer(c *gin.Context, peer *Peer, target string) {
+	c.Header("Location", target)
+	c.Status(http.StatusTemporaryRedirect)
+
+	// Remove the peer
+	m.peersMU.Lock()
+	current, _ := m.peers[peer.UUID]
+	if current == peer {
+		m.removePeer(peer)
+	}
+	m.peersMU.Unlock()
+	if current != peer {
+		return
+	}
+
+	// Report messages queued since the last poll
+	for _, msg := range peer.drainQueue() {
+		m.messageDropped(peer.UUID, msg.MessageID, "drain", "redirected", ErrDraining)
+	}
+	m.emit(Event{Type: EventPeerOffline, PeerUUID: peer.UUID, IPAddr: peer.getIPAddr(), Reason: ReasonRedirected, URL: target})
+	if m.DownCallback != nil {
+		go m.DownCallback(peer.UUID)
+	}
+}
+
+// Moves a server peer to the URL a draining server redirected it to, in place of the endpoint that redirected.
+// The peer keeps its UUID, headers and topics, so the redirect must keep the scheme and go to one of the peer's
+// hosts or its RedirectHosts
+func (p *Peer) followRedirect(m *Manager, resp *http.Response) error {
+	location, err := resp.Location()
+	if err != nil {
+		return errors.New("redirect without a valid Location: " + err.Error())
+	}
+
+	previous := p.serverURL()
+	err = p.checkRedirect(previous, location)
+	if err != nil {
+		return err
+	}
+	p.endpoints.replace(previous, location.String())
+	p.setServerURL(location.String())
+	p.setSessionToken("")
+
+	m.emit(Event{Type: EventServerRedirected, PeerUUID: p.UUID, URL: location.String(), PreviousURL: previous})
+	return nil
+}
+
+// Checks a server peer may follow a redirect from the URL it used to location
+func (p *Peer) checkRedirect(from string, location *url.URL) error {
+	current, err := url.Parse(from)
+	if err != nil {
+		return err
+	}
+	if location.Scheme != current.Scheme {
+		return errors.New("refusing redirect from " + current.Scheme + " to " + location.Scheme + ": " + location.String())
+	}
+
+	// Allow the peer's own hosts and RedirectHosts
+	for _, u := range p.endpoints.urls() {
+		if parsed, err := url.Parse(u); err == nil && parsed.Host == location.Host {
+			return nil
+		}
+	}
+	for _, host := range p.getRedirectHosts() {
+		if host == location.Host || host == location.Hostname() {
+			return nil
+		}
+	}
+	return errors.New("refusing redirect to a host not in RedirectHosts: " + location.Host)
+}
+
+// Returns the extra hosts a draining server may redirect to
+func (p *Peer) getRedirectHosts() []string {
+	p.settingsMU.RLock()
+	defer p.settingsMU.RUnlock()
+	return p.redirectHosts
+}
+
+func (p *Peer) setRedirectHosts(hosts []string) {
+	p.settingsMU.Lock()
+	p.redirectHosts = hosts
+	p.settingsMU.Unlock()
+}
+
+func isRedirect(status int) bool {
+	return status == http.StatusTemporaryRedirect || status == http.StatusPermanentRedirect
+}
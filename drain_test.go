@@ -0,0 +1,121 @@
+package longpoll
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDrainRedirectsClientPeer(t *testing.T) {
+	a, err := NewManager(WithUUID("a"), WithPollLength(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+	b, err := NewManager(WithUUID("b"), WithPollLength(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Stop()
+	serverA := httptest.NewServer(a.Handler())
+	defer serverA.Close()
+	serverB := httptest.NewServer(b.Handler())
+	defer serverB.Close()
+
+	client, err := NewManager(WithUUID("client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+	events, stop := client.Events(64)
+	defer stop()
+	err = client.AddServerPeerWithConfig("server", ServerPeerConfig{
+		URL:           serverA.URL + a.API_Path,
+		RedirectHosts: []string{strings.TrimPrefix(serverB.URL, "http://")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return a.PeerExists("client") })
+
+	// Drain A, the client peer moves to B
+	target := serverB.URL + b.API_Path
+	err = a.Drain(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return b.PeerExists("client") })
+	waitFor(t, func() bool { return !a.PeerExists("client") })
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Type != EventServerRedirected {
+				continue
+			}
+			if e.URL != target || e.PreviousURL != serverA.URL+a.API_Path {
+				t.Fatalf("got redirect from %s to %s", e.PreviousURL, e.URL)
+			}
+			return
+		case <-timeout:
+			t.Fatal("no server_redirected event")
+		}
+	}
+}
+
+func TestFollowRedirectChecks(t *testing.T) {
+	m, err := NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		location string
+		ok       bool
+	}{
+		{"same host", "https://primary.example/poll2", true},
+		{"redirect host", "https://standby.example:8443/poll", true},
+		{"scheme downgrade", "http://primary.example/poll", false},
+		{"scheme downgrade to redirect host", "http://standby.example/poll", false},
+		{"foreign host", "https://evil.example/poll", false},
+		{"same hostname on another port", "https://primary.example:8443/poll", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Peer{
+				UUID:          "server",
+				IsServer:      true,
+				ServerURL:     "https://primary.example/poll",
+				endpoints:     newEndpointSet([]string{"https://primary.example/poll"}, StrategyFailover),
+				redirectHosts: []string{"standby.example"},
+			}
+			resp := &http.Response{StatusCode: 307, Header: http.Header{"Location": {tt.location}}, Request: httptest.NewRequest("GET", "https://primary.example/poll", nil)}
+			err := p.followRedirect(m, resp)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v, want ok %v", err, tt.ok)
+			}
+			want := "https://primary.example/poll"
+			if tt.ok {
+				want = tt.location
+			}
+			if got := p.serverURL(); got != want {
+				t.Fatalf("ServerURL %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+// Polls cond until it is true or 5 seconds pass
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,323 @@
+package longpoll
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// EndpointStrategy How a server peer with several URLs picks the URL for each request
+type EndpointStrategy string
+
+const (
+	StrategyFailover   EndpointStrategy = "failover"    // First healthy URL in the order given (default)
+	StrategyRoundRobin EndpointStrategy = "round_robin" // Rotate through the healthy URLs
+	StrategyRandom     EndpointStrategy = "random"      // Pick a healthy URL at random
+)
+
+// How long an endpoint is skipped after a failure. Doubles with each consecutive failure up to maxEndpointCooldown
+const (
+	endpointCooldown    = 5 * time.Second
+	maxEndpointCooldown = 2 * time.Minute
+)
+
+// EndpointHealth The health of one URL of a server peer
+type EndpointHealth struct {
+	URL                 string    `json:"url"`
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	RetryAt             time.Time `json:"retry_at"` // When an unhealthy endpoint is next tried first
+}
+
+// Validate Checks the strategy is known
+func (s EndpointStrategy) Validate() error {
+	switch s {
+	case "", StrategyFailover, StrategyRoundRobin, StrategyRandom:
+		return nil
+	}
+	return errors.New("unknown endpoint strategy: " + string(s))
+}
+
+// The URLs of a server peer and their health
+type endpointSet struct {
+	mu       sync.Mutex
+	strategy EndpointStrategy
+	list     []*endpoint
+	next     int // Round robin position
+}
+
+type endpoint struct {
+	url       string
+	failures  int
+	lastError string
+	downUntil time.Time
+}
+
+func newEndpointSet(urls []string, strategy EndpointStrategy) *endpointSet {
+	s := &endpointSet{}
+	s.set(urls, strategy)
+	return s
+}
+
+// Replaces the URLs and strategy, keeping the health of URLs that remain
+func (s *endpointSet) set(urls []string, strategy EndpointStrategy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := make(map[string]*endpoint, len(s.list))
+	for _, e := range s.list {
+		existing[e.url] = e
+	}
+	list := make([]*endpoint, 0, len(urls))
+	for _, url := range urls {
+		e, _ := existing[url]
+		if e == nil {
+			e = &endpoint{url: url}
+		}
+		list = append(list, e)
+	}
+	s.list = list
+	s.strategy = strategy
+	s.next = 0
+}
+
+// Returns the URLs
+func (s *endpointSet) urls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	urls := make([]string, len(s.list))
+	for i, e := range s.list {
+		urls[i] = e.url
+	}
+	return urls
+}
+
+// Returns the strategy
+func (s *endpointSet) getStrategy() EndpointStrategy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.strategy
+}
+
+// Returns the endpoints to try for one request: healthy endpoints in strategy order, then the unhealthy ones
+// soonest to recover first, so a request is still attempted when every endpoint has failed
+func (s *endpointSet) order() []*endpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var healthy, unhealthy []*endpoint
+	for _, e := range s.list {
+		if now.Before(e.downUntil) {
+			unhealthy = append(unhealthy, e)
+		} else {
+			healthy = append(healthy, e)
+		}
+	}
+
+	switch s.strategy {
+	case StrategyRoundRobin:
+		if len(healthy) > 0 {
+			start := s.next % len(healthy)
+			healthy = append(healthy[start:], healthy[:start]...)
+			s.next++
+		}
+	case StrategyRandom:
+		rand.Shuffle(len(healthy), func(i, j int) {
+			healthy[i], healthy[j] = healthy[j], healthy[i]
+		})
+	}
+	sort.SliceStable(unhealthy, func(i, j int) bool {
+		return unhealthy[i].downUntil.Before(unhealthy[j].downUntil)
+	})
+	return append(healthy, unhealthy...)
+}
+
+// Marks an endpoint healthy. Returns true if it was unhealthy
+func (s *endpointSet) success(e *endpoint) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recovered := e.failures > 0
+	e.failures = 0
+	e.lastError = ""
+	e.downUntil = time.Time{}
+	return recovered
+}
+
+// Marks an endpoint unhealthy for a cooldown. Returns true if it was healthy
+func (s *endpointSet) failure(e *endpoint, err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.failures++
+	e.lastError = err.Error()
+	cooldown := maxEndpointCooldown
+	if e.failures <= 6 {
+		cooldown = min(endpointCooldown<<(e.failures-1), maxEndpointCooldown)
+	}
+	e.downUntil = time.Now().Add(cooldown)
+	return e.failures == 1
+}
+
+// Replaces a URL eg: when a draining server redirects the peer
+func (s *endpointSet) replace(old string, new string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.list {
+		if e.url == old {
+			e.url = new
+			e.failures = 0
+			e.lastError = ""
+			e.downUntil = time.Time{}
+			return
+		}
+	}
+}
+
+// Takes a snapshot of the health of each endpoint
+func (s *endpointSet) health() []EndpointHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	health := make([]EndpointHealth, len(s.list))
+	for i, e := range s.list {
+		health[i] = EndpointHealth{
+			URL:                 e.url,
+			Healthy:             !now.Before(e.downUntil),
+			ConsecutiveFailures: e.failures,
+			LastError:           e.lastError,
+			RetryAt:             e.downUntil,
+		}
+	}
+	return health
+}
+
+// Sends a request to the server peer's endpoints in strategy order, failing over to the next endpoint on a
+// network error or 5xx reply. A POST may already have been delivered, so it only fails over when the connection
+// could not be made. newRequest builds the request for an endpoint URL. ServerURL is set to the
+// endpoint that replied. Each attempt times out after timeout, including reading the body. Fails fast with a
+// *CircuitOpenError while the peer's circuit breaker is open
+func (p *Peer) do(ctx context.Context, m *Manager, timeout time.Duration, newRequest func(ctx context.Context, url string) (*http.Request, error)) (*http.Response, error) {
+	probe, err := p.allowRequest(m)
+	if err != nil {
+		return nil, err
+	}
+
+	client := p.httpClient()
+	endpoints := p.endpoints.order()
+	var lastErr error
+	for i, e := range endpoints {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		req, err := newRequest(attemptCtx, e.url)
+		if err != nil {
+			cancel()
+			p.recordRequest(m, probe, err)
+			return nil, err
+		}
+
+		// Send request, cancelling the attempt when the body is closed
+		resp, err := client.Do(req)
+		if err != nil {
+			cancel()
+		} else {
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+		}
+		if err == nil && resp.StatusCode < 500 {
+			if p.endpoints.success(e) {
+				m.emit(Event{Type: EventEndpointUp, PeerUUID: p.UUID, URL: e.url})
+			}
+			p.setServerURL(e.url)
+			p.recordRequest(m, probe, nil)
+			return resp, nil
+		}
+
+		// Mark the endpoint unhealthy
+		failure := err
+		if failure == nil {
+			failure = errors.New("endpoint replied " + resp.Status)
+		}
+		if p.endpoints.failure(e, failure) {
+			m.emit(Event{Type: EventEndpointDown, PeerUUID: p.UUID, URL: e.url, Err: failure})
+		}
+
+		// Return the 5xx reply from the last endpoint, or one a POST got
+		failOver := req.Method == http.MethodGet || err != nil && isDialError(err)
+		if err == nil && (i == len(endpoints)-1 || !failOver) {
+			p.setServerURL(e.url)
+			p.recordRequest(m, probe, failure)
+			return resp, nil
+		}
+		if !failOver {
+			p.recordRequest(m, probe, failure)
+			return nil, failure
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		lastErr = failure
+		if i < len(endpoints)-1 {
+			m.metrics.add("longpoll_server_peer_failovers_total", metricLabels("peer", p.UUID), 1)
+		}
+	}
+	if lastErr == nil {
+		lastErr = errors.New("server peer has no URLs")
+	}
+	p.recordRequest(m, probe, lastErr)
+	return nil, lastErr
+}
+
+// Checks whether a request failed before a connection was made, so the server can't have received it
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// Cancels a request's context once its body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Records the endpoint in use
+func (p *Peer) setServerURL(url string) {
+	p.settingsMU.Lock()
+	p.ServerURL = url
+	p.settingsMU.Unlock()
+}
+
+// Returns the endpoint in use
+func (p *Peer) serverURL() string {
+	p.settingsMU.RLock()
+	defer p.settingsMU.RUnlock()
+	return p.ServerURL
+}
+
+// Returns the URLs of a server peer config, URL first
+func (c ServerPeerConfig) urls() []string {
+	var urls []string
+	if c.URL != "" {
+		urls = append(urls, c.URL)
+	}
+	for _, url := range c.URLs {
+		if url != "" && url != c.URL {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
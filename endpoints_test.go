@@ -0,0 +1,96 @@
+package longpoll
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPeerDoFailover(t *testing.T) {
+	var failingHits, healthyHits atomic.Int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failingHits.Add(1)
+		w.WriteHeader(500)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthyHits.Add(1)
+		w.WriteHeader(200)
+	}))
+	defer healthy.Close()
+
+	// A URL nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := "http://" + ln.Addr().String()
+	ln.Close()
+
+	m, err := NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		method      string
+		first       string
+		wantStatus  int
+		wantFailing int32
+		wantHealthy int32
+	}{
+		{"GET fails over on 5xx", "GET", failing.URL, 200, 1, 1},
+		{"POST returns 5xx", "POST", failing.URL, 500, 1, 0},
+		{"GET fails over when refused", "GET", refused, 200, 0, 1},
+		{"POST fails over when refused", "POST", refused, 200, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failingHits.Store(0)
+			healthyHits.Store(0)
+			p := &Peer{UUID: "server", IsServer: true, client: &http.Client{}, endpoints: newEndpointSet([]string{tt.first, healthy.URL}, StrategyFailover)}
+			resp, err := p.do(context.Background(), m, time.Second, func(ctx context.Context, url string) (*http.Request, error) {
+				return http.NewRequestWithContext(ctx, tt.method, url, nil)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus || failingHits.Load() != tt.wantFailing || healthyHits.Load() != tt.wantHealthy {
+				t.Fatalf("got status %d, hits %d/%d, want %d, %d/%d", resp.StatusCode, failingHits.Load(), healthyHits.Load(), tt.wantStatus, tt.wantFailing, tt.wantHealthy)
+			}
+		})
+	}
+}
+
+func TestPeerDoPOSTTimeout(t *testing.T) {
+	// A POST that timed out may have been delivered and must not be sent to the next URL
+	var healthyHits atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthyHits.Add(1)
+	}))
+	defer healthy.Close()
+
+	m, err := NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Peer{UUID: "server", IsServer: true, client: &http.Client{}, endpoints: newEndpointSet([]string{slow.URL, healthy.URL}, StrategyFailover)}
+	_, err = p.do(context.Background(), m, 50*time.Millisecond, func(ctx context.Context, url string) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "POST", url, nil)
+	})
+	if err == nil {
+		t.Fatal("timed out POST succeeded")
+	}
+	if healthyHits.Load() != 0 {
+		t.Fatal("timed out POST was sent to the next URL")
+	}
+}
@@ -0,0 +1,279 @@
+package longpoll
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// EventType The kind of lifecycle event
+type EventType string
+
+const (
+	EventPeerCreated          EventType = "peer_created"           // A client peer registered or a server peer was added
+	EventPeerOnline           EventType = "peer_online"            // A peer came online
+	EventPeerOffline          EventType = "peer_offline"           // A peer went offline, see Reason and Err
+	EventPeerExpired          EventType = "peer_expired"           // A client peer stopped polling and was removed by garbage collection
+	EventMessageDropped       EventType = "message_dropped"        // A message could not be delivered, see Op and Reason
+	EventRemoteManagerChanged EventType = "remote_manager_changed" // A server peer replied with a different manager UUID eg: after a restart
+	EventPollError            EventType = "poll_error"             // A request to a server peer failed, see Op, StatusCode and Err
+	EventRequestRejected      EventType = "request_rejected"       // A request or message was rejected, see Err
+	EventError                EventType = "error"                  // Any other failure, see Err
+	EventConfigChanged        EventType = "config_changed"         // Reload changed a setting or server peer, Reason is the config key eg: poll_length
+	EventDraining             EventType = "draining"               // Drain was called, see URL
+	EventServerRedirected     EventType = "server_redirected"      // A draining server peer redirected polling to URL
+	EventEndpointDown         EventType = "endpoint_down"          // A URL of a server peer failed and is skipped for a while, see URL and Err
+	EventEndpointUp           EventType = "endpoint_up"            // A URL of a server peer recovered, see URL
+	EventCircuitChanged       EventType = "circuit_changed"        // A server peer's circuit breaker changed state, Reason is the new CircuitState
+)
+
+// Reasons for EventPeerOffline
+const (
+	ReasonExpired      = "expired"       // Client peer stopped polling
+	ReasonDeleted      = "deleted"       // DeletePeer was called
+	ReasonKicked       = "kicked"        // KickPeer was called
+	ReasonNetworkError = "network_error" // Request to a server peer failed
+	ReasonBadStatus    = "bad_status"    // Server peer replied with a non 2xx status
+	ReasonRedirected   = "redirected"    // Client peer was redirected by Drain
+)
+
+// Event A lifecycle event. Fields that don't apply to the event type are empty
+type Event struct {
+	Type       EventType
+	Time       time.Time
+	PeerUUID   string
+	Reason     string // Why the event happened eg: ReasonExpired or "buffer_full"
+	Err        error
+	Op         string // Operation eg: "send", "fanout", "poll", "post"
+	MessageID  string
+	IPAddr     string
+	StatusCode int
+
+	RemoteManagerUUID         string // New remote manager UUID for EventRemoteManagerChanged
+	PreviousRemoteManagerUUID string // Previous remote manager UUID for EventRemoteManagerChanged
+
+	URL         string // Drain target or new server URL for EventDraining, EventServerRedirected and ReasonRedirected
+	PreviousURL string // Previous server URL for EventServerRedirected
+}
+
+// String Formats the event for logs
+func (e Event) String() string {
+	s := string(e.Type)
+	if e.PeerUUID != "" {
+		s += " peer=" + e.PeerUUID
+	}
+	if e.Op != "" {
+		s += " op=" + e.Op
+	}
+	if e.Reason != "" {
+		s += " reason=" + e.Reason
+	}
+	if e.MessageID != "" {
+		s += " message_id=" + e.MessageID
+	}
+	if e.IPAddr != "" {
+		s += " ip=" + e.IPAddr
+	}
+	if e.StatusCode != 0 {
+		s += " status=" + strconv.Itoa(e.StatusCode)
+	}
+	if e.Type == EventRemoteManagerChanged {
+		s += " from=" + stringPlaceHolder(e.PreviousRemoteManagerUUID) + " to=" + stringPlaceHolder(e.RemoteManagerUUID)
+	}
+	if e.URL != "" {
+		if e.PreviousURL != "" {
+			s += " from=" + e.PreviousURL
+		}
+		s += " url=" + e.URL
+	}
+	if e.Err != nil {
+		s += " err=" + e.Err.Error()
+	}
+	return s
+}
+
+// Delivers events to listeners in order on a single goroutine
+type eventBus struct {
+	listeners   map[int]func(Event)
+	listenersMU sync.Mutex
+	nextID      int
+	queue       chan Event
+	startOnce   sync.Once
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		listeners: make(map[int]func(Event)),
+		queue:     make(chan Event, 1024),
+	}
+}
+
+// AddEventListener Registers a function to call for every event. Listeners are called in order on a
+// single goroutine, so slow listeners delay later events. Call the returned function to remove the listener
+func (m *Manager) AddEventListener(listener func(Event)) (remove func()) {
+	bus := m.events
+	bus.startOnce.Do(func() {
+		go m.dispatchEvents()
+	})
+
+	bus.listenersMU.Lock()
+	id := bus.nextID
+	bus.nextID++
+	bus.listeners[id] = listener
+	bus.listenersMU.Unlock()
+
+	return func() {
+		bus.listenersMU.Lock()
+		delete(bus.listeners, id)
+		bus.listenersMU.Unlock()
+	}
+}
+
+// Events Returns a channel receiving every event. Events are dropped if the channel buffer is full.
+// Call the returned function to stop receiving and close the channel
+func (m *Manager) Events(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	var mu sync.Mutex
+	closed := false
+
+	remove := m.AddEventListener(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- e:
+		default:
+			m.metrics.add("longpoll_events_dropped_total", "", 1)
+		}
+	})
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			remove()
+			mu.Lock()
+			closed = true
+			close(ch)
+			mu.Unlock()
+		})
+	}
+}
+
+// Emits an event to the listeners without blocking
+func (m *Manager) emit(e Event) {
+	e.Time = time.Now()
+	m.logEvent(e)
+
+	// Skip the queue if nobody is listening
+	bus := m.events
+	bus.listenersMU.Lock()
+	listening := len(bus.listeners) > 0
+	bus.listenersMU.Unlock()
+	if !listening {
+		return
+	}
+
+	select {
+	case bus.queue <- e:
+	default:
+		m.metrics.add("longpoll_events_dropped_total", "", 1)
+	}
+}
+
+// Calls the listeners for each queued event until the manager is stopped
+func (m *Manager) dispatchEvents() {
+	bus := m.events
+	for {
+		select {
+		case <-m.stop:
+			return
+		case e := <-bus.queue:
+			bus.listenersMU.Lock()
+			listeners := make([]func(Event), 0, len(bus.listeners))
+			for id := 0; id < bus.nextID; id++ {
+				if listener, ok := bus.listeners[id]; ok {
+					listeners = append(listeners, listener)
+				}
+			}
+			bus.listenersMU.Unlock()
+
+			for _, listener := range listeners {
+				listener(e)
+			}
+		}
+	}
+}
+
+// Logs an event to Manager.Logger with a level matching its severity
+func (m *Manager) logEvent(e Event) {
+	level := slog.LevelDebug
+	switch e.Type {
+	case EventPeerOffline, EventPeerExpired, EventRemoteManagerChanged, EventConfigChanged,
+		EventDraining, EventServerRedirected, EventEndpointDown, EventEndpointUp,
+		EventCircuitChanged:
+		level = slog.LevelInfo
+	case EventMessageDropped:
+		level = slog.LevelWarn
+	case EventError:
+		level = slog.LevelError
+	}
+
+	logger := m.logger()
+	if !logger.Enabled(context.Background(), level) {
+		return
+	}
+
+	// Only include fields that apply
+	attrs := make([]slog.Attr, 0, 8)
+	if e.PeerUUID != "" {
+		attrs = append(attrs, slog.String("peer", e.PeerUUID))
+	}
+	if e.Op != "" {
+		attrs = append(attrs, slog.String("op", e.Op))
+	}
+	if e.Reason != "" {
+		attrs = append(attrs, slog.String("reason", e.Reason))
+	}
+	if e.MessageID != "" {
+		attrs = append(attrs, slog.String("message_id", e.MessageID))
+	}
+	if e.IPAddr != "" {
+		attrs = append(attrs, slog.String("ip", e.IPAddr))
+	}
+	if e.StatusCode != 0 {
+		attrs = append(attrs, slog.Int("status", e.StatusCode))
+	}
+	if e.Type == EventRemoteManagerChanged {
+		attrs = append(attrs,
+			slog.String("remote_manager_uuid", e.RemoteManagerUUID),
+			slog.String("previous_remote_manager_uuid", e.PreviousRemoteManagerUUID),
+		)
+	}
+	if e.URL != "" {
+		attrs = append(attrs, slog.String("url", e.URL))
+	}
+	if e.PreviousURL != "" {
+		attrs = append(attrs, slog.String("previous_url", e.PreviousURL))
+	}
+	if e.Err != nil {
+		attrs = append(attrs, slog.String("err", e.Err.Error()))
+	}
+	logger.LogAttrs(context.Background(), level, string(e.Type), attrs...)
+}
+
+// Records and emits a message that could not be delivered
+func (m *Manager) messageDropped(peerUUID string, messageID string, op string, reason string, err error) {
+	m.metrics.add("longpoll_messages_dropped_total", metricLabels("op", op, "reason", reason), 1)
+	m.emit(Event{
+		Type:      EventMessageDropped,
+		PeerUUID:  peerUUID,
+		MessageID: messageID,
+		Op:        op,
+		Reason:    reason,
+		Err:       err,
+	})
+}
@@ -0,0 +1,179 @@
+package longpoll
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Returns the value of a counter without creating it
+func counterValue(m *Manager, name string, labels string) float64 {
+	m.metrics.mu.Lock()
+	defer m.metrics.mu.Unlock()
+	v := m.metrics.series[name].values[labels]
+	if v == nil {
+		return 0
+	}
+	return v.value
+}
+
+func TestEventListenerOrder(t *testing.T) {
+	m, err := NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	var mu sync.Mutex
+	var calls []string
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		m.AddEventListener(func(e Event) {
+			mu.Lock()
+			calls = append(calls, name+":"+e.PeerUUID)
+			mu.Unlock()
+		})
+	}
+	for i := 0; i < 3; i++ {
+		m.emit(Event{Type: EventPeerCreated, PeerUUID: strconv.Itoa(i)})
+	}
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(calls) == 9
+	})
+
+	// Events are delivered in order, each to the listeners in the order they were added
+	want := []string{"a:0", "b:0", "c:0", "a:1", "b:1", "c:1", "a:2", "b:2", "c:2"}
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("got calls %v, want %v", calls, want)
+		}
+	}
+}
+
+func TestEventListenerRemove(t *testing.T) {
+	m, err := NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	var mu sync.Mutex
+	removedCalls := 0
+	remove := m.AddEventListener(func(e Event) {
+		mu.Lock()
+		removedCalls++
+		mu.Unlock()
+	})
+	seen := make(chan Event, 2)
+	m.AddEventListener(func(e Event) { seen <- e })
+
+	m.emit(Event{Type: EventPeerCreated, PeerUUID: "before"})
+	<-seen
+	remove()
+	remove()
+	m.emit(Event{Type: EventPeerCreated, PeerUUID: "after"})
+	<-seen
+
+	// The listener added later ran for both events, the removed one only for the first
+	mu.Lock()
+	defer mu.Unlock()
+	if removedCalls != 1 {
+		t.Fatalf("removed listener called %d times, want 1", removedCalls)
+	}
+}
+
+func TestEventsChannel(t *testing.T) {
+	m, err := NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	events, stop := m.Events(4)
+	m.emit(Event{Type: EventPeerOnline, PeerUUID: "a"})
+	select {
+	case e := <-events:
+		if e.Type != EventPeerOnline || e.PeerUUID != "a" || e.Time.IsZero() {
+			t.Fatalf("got event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+
+	// Stopping closes the channel and can be repeated
+	stop()
+	stop()
+	m.emit(Event{Type: EventPeerOnline, PeerUUID: "b"})
+	if e, ok := <-events; ok {
+		t.Fatalf("got event %+v after stop", e)
+	}
+}
+
+func TestEventsChannelDrop(t *testing.T) {
+	m, err := NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	events, stop := m.Events(1)
+	defer stop()
+	seen := make(chan struct{}, 3)
+	m.AddEventListener(func(e Event) { seen <- struct{}{} })
+	for i := 0; i < 3; i++ {
+		m.emit(Event{Type: EventPeerCreated, PeerUUID: strconv.Itoa(i)})
+	}
+	for i := 0; i < 3; i++ {
+		<-seen
+	}
+
+	// The full channel keeps the first event and the rest are counted as dropped
+	e := <-events
+	if e.PeerUUID != "0" {
+		t.Fatalf("got event for %s, want 0", e.PeerUUID)
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("got extra event %+v", e)
+	default:
+	}
+	if dropped := counterValue(m, "longpoll_events_dropped_total", ""); dropped != 2 {
+		t.Fatalf("got %v dropped events, want 2", dropped)
+	}
+}
+
+func TestEventQueueDrop(t *testing.T) {
+	m, err := NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	// Block the dispatcher in a listener
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	m.AddEventListener(func(e Event) {
+		once.Do(func() {
+			close(entered)
+			<-release
+		})
+	})
+	m.emit(Event{Type: EventPeerCreated})
+	<-entered
+
+	// emit never blocks, events beyond the queue size are dropped
+	queued := cap(m.events.queue)
+	for i := 0; i < queued+1; i++ {
+		m.emit(Event{Type: EventPeerCreated})
+	}
+	close(release)
+	if dropped := counterValue(m, "longpoll_events_dropped_total", ""); dropped != 1 {
+		t.Fatalf("got %v dropped events, want 1", dropped)
+	}
+}
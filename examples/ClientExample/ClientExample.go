@@ -10,33 +10,30 @@ import (
 )
 
 func main() {
-	// Create a new LongPoll Manager with default settings
-	manager := longpoll.NewDefaultManager()
-
-	// Change port to not clash with the ServerExample
-	manager.API_Port = 8081
-
-	// Set custom UUID
-	manager.UUID = "client1"
-
-	// Set callback functions
-	upCallback := func(peerUUID string) {
-		log.Println("Peer Up:", peerUUID)
-	}
-	manager.UpCallback = &upCallback
-
-	downCallback := func(peerUUID string) {
-		log.Println("Peer Down:", peerUUID)
-	}
-	manager.DownCallback = &downCallback
-
-	receiveCallback := func(peerUUID string, message longpoll.Message) {
-		fmt.Println("Received Message:", string(message.Data))
+	// Create a new LongPoll Manager with callback functions
+	manager, err := longpoll.NewManager(
+		// Change port to not clash with the ServerExample
+		longpoll.WithPort(8081),
+
+		// Set custom UUID
+		longpoll.WithUUID("client1"),
+
+		longpoll.OnUp(func(peerUUID string) {
+			log.Println("Peer Up:", peerUUID)
+		}),
+		longpoll.OnDown(func(peerUUID string) {
+			log.Println("Peer Down:", peerUUID)
+		}),
+		longpoll.OnReceive(func(peerUUID string, message longpoll.Message) {
+			fmt.Println("Received Message:", string(message.Data))
+		}),
+	)
+	if err != nil {
+		log.Fatal(err)
 	}
-	manager.ReceiveCallback = &receiveCallback
 
 	// Start the LongPoll Manager
-	err := manager.Start()
+	err = manager.Start()
 	if err != nil {
 		log.Fatal(err)
 	}
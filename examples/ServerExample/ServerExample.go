@@ -10,27 +10,24 @@ import (
 )
 
 func main() {
-	// Create a new LongPoll Manager with default settings
-	manager := longpoll.NewDefaultManager()
-
-	// Set callback functions
-	upCallback := func(peerUUID string) {
-		log.Println("Peer Up:", peerUUID)
-	}
-	manager.UpCallback = &upCallback
-
-	downCallback := func(peerUUID string) {
-		log.Println("Peer Down:", peerUUID)
-	}
-	manager.DownCallback = &downCallback
-
-	receiveCallback := func(peerUUID string, message longpoll.Message) {
-		fmt.Println("Received Message:", string(message.Data))
+	// Create a new LongPoll Manager with callback functions
+	manager, err := longpoll.NewManager(
+		longpoll.OnUp(func(peerUUID string) {
+			log.Println("Peer Up:", peerUUID)
+		}),
+		longpoll.OnDown(func(peerUUID string) {
+			log.Println("Peer Down:", peerUUID)
+		}),
+		longpoll.OnReceive(func(peerUUID string, message longpoll.Message) {
+			fmt.Println("Received Message:", string(message.Data))
+		}),
+	)
+	if err != nil {
+		log.Fatal(err)
 	}
-	manager.ReceiveCallback = &receiveCallback
 
 	// Start the LongPoll Manager
-	err := manager.Start()
+	err = manager.Start()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,127 @@
+package longpoll
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HealthReport The health of the manager and its server peers
+type HealthReport struct {
+	Live        bool               `json:"live"`     // The API listener is serving
+	Ready       bool               `json:"ready"`    // Live, not draining and every required server peer is online
+	Draining    bool               `json:"draining"` // Drain was called
+	ServerPeers []ServerPeerHealth `json:"server_peers"`
+}
+
+// ServerPeerHealth The health of a server peer's poll loop
+type ServerPeerHealth struct {
+	UUID                string       `json:"uuid"`
+	URL                 string       `json:"url"`
+	Required            bool         `json:"required"`
+	Online              bool         `json:"online"`
+	LastSuccess         time.Time    `json:"last_success"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	LastError           string       `json:"last_error,omitempty"`
+	RemoteManagerUUID   string       `json:"remote_manager_uuid,omitempty"`
+	Circuit             CircuitState `json:"circuit"` // State of the circuit breaker
+
+	Endpoints []EndpointHealth `json:"endpoints"` // Health of each URL (see ServerPeerConfig.URLs)
+}
+
+// Health Reports whether the API is serving and whether required server peers are online
+func (m *Manager) Health() HealthReport {
+	report := HealthReport{
+		Live:        m.serving.Load(),
+		ServerPeers: []ServerPeerHealth{},
+	}
+
+	m.peersMU.RLock()
+	for _, peer := range m.peers {
+		if peer.IsServer {
+			report.ServerPeers = append(report.ServerPeers, peer.health())
+		}
+	}
+	m.peersMU.RUnlock()
+
+	sort.Slice(report.ServerPeers, func(i, j int) bool {
+		return report.ServerPeers[i].UUID < report.ServerPeers[j].UUID
+	})
+
+	// Ready if live, not draining and no required server peer is offline
+	_, report.Draining = m.Draining()
+	report.Ready = report.Live && !report.Draining
+	for _, peer := range report.ServerPeers {
+		if peer.Required && !peer.Online {
+			report.Ready = false
+		}
+	}
+	return report
+}
+
+// HealthHandler Returns a http.Handler replying 200 while the API is serving and 503 otherwise
+func (m *Manager) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := m.Health()
+		writeHealth(w, report, report.Live)
+	})
+}
+
+// ReadyHandler Returns a http.Handler replying 200 while the manager is ready and 503 otherwise
+func (m *Manager) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := m.Health()
+		writeHealth(w, report, report.Ready)
+	})
+}
+
+// Registers the health routes on the API
+func (m *Manager) healthRoutes(r gin.IRoutes) {
+	r.GET("/healthz", gin.WrapH(m.HealthHandler()))
+	r.GET("/readyz", gin.WrapH(m.ReadyHandler()))
+}
+
+func writeHealth(w http.ResponseWriter, report HealthReport, ok bool) {
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// Records the outcome of a poll to a server peer
+func (p *Peer) recordPoll(err error) {
+	p.healthMU.Lock()
+	defer p.healthMU.Unlock()
+	if err != nil {
+		p.consecutiveFailures++
+		p.lastError = err.Error()
+		return
+	}
+	p.consecutiveFailures = 0
+	p.lastError = ""
+	p.lastSuccess = time.Now()
+}
+
+// Takes a snapshot of a server peer's health
+func (p *Peer) health() ServerPeerHealth {
+	p.healthMU.Lock()
+	defer p.healthMU.Unlock()
+	return ServerPeerHealth{
+		UUID:                p.UUID,
+		URL:                 p.serverURL(),
+		Required:            p.required,
+		Online:              p.Online,
+		LastSuccess:         p.lastSuccess,
+		ConsecutiveFailures: p.consecutiveFailures,
+		LastError:           p.lastError,
+		RemoteManagerUUID:   p.getRemoteManagerUUID(),
+		Circuit:             p.circuitState(),
+		Endpoints:           p.endpoints.health(),
+	}
+}
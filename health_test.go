@@ -0,0 +1,80 @@
+package longpoll
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthStatus(t *testing.T) {
+	// A server peer that is up and a URL that refuses connections
+	upstream, err := NewManager(WithUUID("upstream"), WithPollLength(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Stop()
+	up := httptest.NewServer(upstream.Handler())
+	defer up.Close()
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	tests := []struct {
+		name    string
+		serve   bool
+		drain   bool
+		peer    *ServerPeerConfig
+		healthz int
+		readyz  int
+	}{
+		{"not serving", false, false, nil, 503, 503},
+		{"serving", true, false, nil, 200, 200},
+		{"draining", true, true, nil, 200, 503},
+		{"required server peer offline", true, false, &ServerPeerConfig{URL: down.URL, Required: true}, 200, 503},
+		{"optional server peer offline", true, false, &ServerPeerConfig{URL: down.URL}, 200, 200},
+		{"required server peer online", true, false, &ServerPeerConfig{URL: up.URL + upstream.API_Path, Required: true}, 200, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewManager(WithHealthChecks())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Stop()
+			if tt.serve {
+				ln, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = m.Serve(ln)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.drain {
+				err := m.Drain("http://standby.example/poll")
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.peer != nil {
+				err := m.AddServerPeerWithConfig("server", *tt.peer)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.peer.URL != down.URL {
+					waitFor(t, func() bool { return m.Health().ServerPeers[0].Online })
+				}
+			}
+
+			handler := m.Handler()
+			for path, want := range map[string]int{"/healthz": tt.healthz, "/readyz": tt.readyz} {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+				if w.Code != want {
+					t.Errorf("%s: got %d, want %d: %s", path, w.Code, want, w.Body.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package longpoll
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+var (
+	ErrBodyTooLarge   = errors.New("body too large")  // Body exceeds InboundLimits.MaxBodySize (413)
+	ErrInvalidUUID    = errors.New("invalid uuid")    // uuid header is missing or malformed (400)
+	ErrInvalidMessage = errors.New("invalid message") // Message could not be parsed or exceeds InboundLimits (400)
+)
+
+// InboundLimits Limits applied to inbound requests and messages (zero values are unlimited)
+type InboundLimits struct {
+	MaxBodySize        int64          // Maximum POST body or poll response size in bytes
+	MaxDataSize        int            // Maximum size of Message.Data in bytes
+	MaxAttributes      int            // Maximum number of Message.Attributes
+	MaxAttributeLength int            // Maximum length of an attribute key or value
+	MaxMessageIDLength int            // Maximum length of Message.MessageID
+	MaxUUIDLength      int            // Maximum length of the uuid header
+	UUIDPattern        *regexp.Regexp // Pattern the uuid header must match eg: ^[a-zA-Z0-9-]+$
+}
+
+// Checks the format of a peer UUID from a uuid header
+func (l InboundLimits) checkUUID(uuid string) error {
+	if l.MaxUUIDLength > 0 && len(uuid) > l.MaxUUIDLength {
+		return fmt.Errorf("%w: longer than %d", ErrInvalidUUID, l.MaxUUIDLength)
+	}
+	if l.UUIDPattern != nil && !l.UUIDPattern.MatchString(uuid) {
+		return fmt.Errorf("%w: does not match %s", ErrInvalidUUID, l.UUIDPattern)
+	}
+	return nil
+}
+
+// Reads a body of at most limit bytes (0 is unlimited)
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, ErrBodyTooLarge
+	}
+	return body, nil
+}
+
+// Parses a message and checks it against the limits
+func decodeMessage(body []byte, limits InboundLimits) (Message, error) {
+	var msg Message
+	err := json.Unmarshal(body, &msg)
+	if err != nil {
+		return Message{}, fmt.Errorf("%w: failed to parse message", ErrInvalidMessage)
+	}
+
+	// Check the message against the limits
+	if limits.MaxDataSize > 0 && len(msg.Data) > limits.MaxDataSize {
+		return Message{}, fmt.Errorf("%w: data larger than %d bytes", ErrInvalidMessage, limits.MaxDataSize)
+	}
+	if limits.MaxMessageIDLength > 0 && len(msg.MessageID) > limits.MaxMessageIDLength {
+		return Message{}, fmt.Errorf("%w: message_id longer than %d", ErrInvalidMessage, limits.MaxMessageIDLength)
+	}
+	if limits.MaxAttributes > 0 && len(msg.Attributes) > limits.MaxAttributes {
+		return Message{}, fmt.Errorf("%w: more than %d attributes", ErrInvalidMessage, limits.MaxAttributes)
+	}
+	if limits.MaxAttributeLength > 0 {
+		for k, v := range msg.Attributes {
+			if len(k) > limits.MaxAttributeLength || len(v) > limits.MaxAttributeLength {
+				return Message{}, fmt.Errorf("%w: attribute longer than %d", ErrInvalidMessage, limits.MaxAttributeLength)
+			}
+		}
+	}
+	return msg, nil
+}
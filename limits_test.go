@@ -0,0 +1,138 @@
+package longpoll
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var testLimits = InboundLimits{
+	MaxBodySize:        1024,
+	MaxDataSize:        16,
+	MaxAttributes:      2,
+	MaxAttributeLength: 8,
+	MaxMessageIDLength: 8,
+	MaxUUIDLength:      16,
+	UUIDPattern:        regexp.MustCompile(`^[a-z0-9-]+$`),
+}
+
+func TestCheckUUID(t *testing.T) {
+	tests := []struct {
+		name string
+		uuid string
+		want error
+	}{
+		{"valid", "peer-1", nil},
+		{"too long", strings.Repeat("a", 17), ErrInvalidUUID},
+		{"bad characters", "peer/../1", ErrInvalidUUID},
+		{"glob characters", "*", ErrInvalidUUID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := testLimits.checkUUID(tt.uuid)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadLimited(t *testing.T) {
+	body, err := readLimited(strings.NewReader("12345"), 5)
+	if err != nil || string(body) != "12345" {
+		t.Fatalf("at limit: got %q, %v", body, err)
+	}
+	_, err = readLimited(strings.NewReader("123456"), 5)
+	if !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("over limit: got %v, want %v", err, ErrBodyTooLarge)
+	}
+	body, err = readLimited(strings.NewReader("123456"), 0)
+	if err != nil || string(body) != "123456" {
+		t.Fatalf("unlimited: got %q, %v", body, err)
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want error
+	}{
+		{"valid", `{"data":"aGVsbG8=","attributes":{"a":"b"},"message_id":"1"}`, nil},
+		{"not json", `{"data":`, ErrInvalidMessage},
+		{"data too large", `{"data":"` + strings.Repeat("A", 24) + `"}`, ErrInvalidMessage},
+		{"too many attributes", `{"attributes":{"a":"1","b":"2","c":"3"}}`, ErrInvalidMessage},
+		{"attribute key too long", `{"attributes":{"aaaaaaaaa":"1"}}`, ErrInvalidMessage},
+		{"attribute value too long", `{"attributes":{"a":"111111111"}}`, ErrInvalidMessage},
+		{"message id too long", `{"message_id":"123456789"}`, ErrInvalidMessage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeMessage([]byte(tt.body), testLimits)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// Checks every message decodeMessage accepts is within the limits
+func FuzzDecodeMessage(f *testing.F) {
+	f.Add([]byte(`{"data":"aGVsbG8=","attributes":{"a":"b"},"message_id":"1"}`))
+	f.Add([]byte(`{"attributes":{"a":"1","b":"2","c":"3"}}`))
+	f.Add([]byte(`{"data":null,"attributes":null}`))
+	f.Add([]byte(`[]`))
+	f.Fuzz(func(t *testing.T, body []byte) {
+		msg, err := decodeMessage(body, testLimits)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidMessage) {
+				t.Fatalf("error does not wrap ErrInvalidMessage: %v", err)
+			}
+			return
+		}
+		if len(msg.Data) > testLimits.MaxDataSize || len(msg.MessageID) > testLimits.MaxMessageIDLength || len(msg.Attributes) > testLimits.MaxAttributes {
+			t.Fatalf("message exceeds limits: %+v", msg)
+		}
+		for k, v := range msg.Attributes {
+			if len(k) > testLimits.MaxAttributeLength || len(v) > testLimits.MaxAttributeLength {
+				t.Fatalf("attribute exceeds limits: %q=%q", k, v)
+			}
+		}
+	})
+}
+
+// Checks handlePOST only delivers messages within the limits and answers everything else with a client error
+func FuzzHandlePOST(f *testing.F) {
+	f.Add("peer-1", []byte(`{"data":"aGVsbG8=","message_id":"1"}`))
+	f.Add("peer-1", []byte(`{"data":`))
+	f.Add("*", []byte(`{}`))
+	f.Add("", []byte(`{}`))
+	f.Add("peer-1", bytes.Repeat([]byte("A"), 2048))
+
+	m, err := NewManager(WithInboundLimits(testLimits))
+	if err != nil {
+		f.Fatal(err)
+	}
+	handler := m.Handler()
+
+	f.Fuzz(func(t *testing.T, uuid string, body []byte) {
+		r := httptest.NewRequest("POST", m.API_Path, bytes.NewReader(body))
+		r.Header.Set("uuid", uuid)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		switch w.Code {
+		case 200:
+			_, err := decodeMessage(body, testLimits)
+			if err != nil {
+				t.Fatalf("accepted a message decodeMessage rejects: %v", err)
+			}
+		case 400, 413:
+		default:
+			t.Fatalf("unexpected status %d", w.Code)
+		}
+	})
+}
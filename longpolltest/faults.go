@@ -0,0 +1,308 @@
+package longpolltest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FaultKind The type of fault to inject into a request
+type FaultKind int
+
+const (
+	FaultDrop     FaultKind = iota // Process the request but lose the response
+	FaultTruncate                  // Process the request but cut the response body short
+	FaultStatus                    // Reply with Fault.Status without processing the request
+	FaultReset                     // Reset the connection without processing the request
+)
+
+// Fault A fault to apply to matching requests
+type Fault struct {
+	Kind   FaultKind
+	Method string // Only apply to requests with this method eg: "POST" (empty matches all)
+	Status int    // Status code to reply with for FaultStatus eg: 503
+	Count  int    // Number of requests to apply the fault to (0 applies until cleared)
+}
+
+// Faults A scriptable set of network faults. Use Transport() to apply them to outgoing
+// requests (Peer.pollGET/pollPOST) and Middleware() to apply them to the server handler
+type Faults struct {
+	mu         sync.Mutex
+	latency    time.Duration
+	faults     []*Fault
+	partitions map[[2]string]bool
+}
+
+// ErrPartitioned Returned by the fault transport for requests between partitioned peers
+var ErrPartitioned = errors.New("network partitioned")
+
+// NewFaults Creates an empty set of faults
+func NewFaults() *Faults {
+	return &Faults{
+		partitions: make(map[[2]string]bool),
+	}
+}
+
+// SetLatency Delays every request by d
+func (f *Faults) SetLatency(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency = d
+}
+
+// Inject Queues a fault. Faults are applied in the order they were injected
+func (f *Faults) Inject(fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &fault)
+}
+
+// DropNext Loses the responses of the next n requests
+func (f *Faults) DropNext(n int) {
+	f.Inject(Fault{Kind: FaultDrop, Count: n})
+}
+
+// TruncateNext Cuts the response bodies of the next n requests short
+func (f *Faults) TruncateNext(n int) {
+	f.Inject(Fault{Kind: FaultTruncate, Count: n})
+}
+
+// FailNext Replies to the next n requests with status eg: 503
+func (f *Faults) FailNext(n int, status int) {
+	f.Inject(Fault{Kind: FaultStatus, Status: status, Count: n})
+}
+
+// ResetNext Resets the connections of the next n requests
+func (f *Faults) ResetNext(n int) {
+	f.Inject(Fault{Kind: FaultReset, Count: n})
+}
+
+// Partition Blocks all requests between the peers a and b
+func (f *Faults) Partition(a string, b string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.partitions[partitionKey(a, b)] = true
+}
+
+// Heal Removes all partitions
+func (f *Faults) Heal() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.partitions = make(map[[2]string]bool)
+}
+
+// Clear Removes all latency, queued faults and partitions
+func (f *Faults) Clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency = 0
+	f.faults = nil
+	f.partitions = make(map[[2]string]bool)
+}
+
+// Transport Wraps next so outgoing requests are subject to the faults
+func (f *Faults) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &faultTransport{faults: f, next: next}
+}
+
+// Middleware Returns a gin middleware (see Manager.API_Middleware) so incoming requests are subject to the faults
+func (f *Faults) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Apply latency
+		if !sleepContext(c.Request, f.getLatency()) {
+			c.Abort()
+			return
+		}
+
+		// Check partitions
+		if f.partitioned(c.Request.Header.Get("uuid"), hostName(c.Request.Host)) {
+			panic(http.ErrAbortHandler)
+		}
+
+		fault := f.next(c.Request.Method)
+		if fault == nil {
+			c.Next()
+			return
+		}
+
+		switch fault.Kind {
+		case FaultReset:
+			panic(http.ErrAbortHandler)
+		case FaultStatus:
+			c.AbortWithStatus(fault.Status)
+		case FaultDrop, FaultTruncate:
+			// Run the handler into a buffer
+			original := c.Writer
+			buffered := &bufferedWriter{ResponseWriter: original, status: http.StatusOK}
+			c.Writer = buffered
+			c.Next()
+			c.Writer = original
+
+			if fault.Kind == FaultTruncate {
+				// Write half of the response and flush it before cutting the connection
+				body := buffered.body.Bytes()
+				original.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				original.WriteHeader(buffered.status)
+				original.Write(body[:len(body)/2])
+				original.Flush()
+			}
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// Takes the next fault matching method, or nil if there is none
+func (f *Faults) next(method string) *Fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, fault := range f.faults {
+		if fault.Method != "" && fault.Method != method {
+			continue
+		}
+		if fault.Count > 0 {
+			fault.Count--
+			if fault.Count == 0 {
+				f.faults = append(f.faults[:i], f.faults[i+1:]...)
+			}
+		}
+		return fault
+	}
+	return nil
+}
+
+func (f *Faults) getLatency() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.latency
+}
+
+func (f *Faults) partitioned(a string, b string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.partitions[partitionKey(a, b)]
+}
+
+type faultTransport struct {
+	faults *Faults
+	next   http.RoundTripper
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Apply latency
+	if !sleepContext(req, t.faults.getLatency()) {
+		return nil, req.Context().Err()
+	}
+
+	// Check partitions
+	if t.faults.partitioned(req.Header.Get("uuid"), req.URL.Hostname()) {
+		return nil, ErrPartitioned
+	}
+
+	fault := t.faults.next(req.Method)
+	if fault == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	switch fault.Kind {
+	case FaultReset:
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	case FaultStatus:
+		return &http.Response{
+			Status:     strconv.Itoa(fault.Status) + " " + http.StatusText(fault.Status),
+			StatusCode: fault.Status,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     make(http.Header),
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	}
+
+	// Send the request
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch fault.Kind {
+	case FaultDrop:
+		resp.Body.Close()
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	case FaultTruncate:
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body[:len(body)/2]), errReader{io.ErrUnexpectedEOF}))
+	}
+	return resp, nil
+}
+
+// Buffers a response so it can be dropped or truncated
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status  int
+	written bool
+	body    bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(code int)        { w.status = code; w.written = true }
+func (w *bufferedWriter) WriteHeaderNow()             { w.written = true }
+func (w *bufferedWriter) Write(b []byte) (int, error) { w.written = true; return w.body.Write(b) }
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	w.written = true
+	return w.body.WriteString(s)
+}
+func (w *bufferedWriter) Status() int   { return w.status }
+func (w *bufferedWriter) Size() int     { return w.body.Len() }
+func (w *bufferedWriter) Written() bool { return w.written }
+func (w *bufferedWriter) Flush()        {}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// Sleeps for d unless the request is cancelled first. Returns false if cancelled
+func sleepContext(req *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(d):
+		return true
+	case <-req.Context().Done():
+		return false
+	}
+}
+
+func partitionKey(a string, b string) [2]string {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+func hostName(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport
+	}
+	return host
+}
@@ -0,0 +1,238 @@
+// Package longpolltest connects several LongPoll Managers in one process for integration tests
+package longpolltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lampy255/go-longpoll"
+)
+
+// Harness Connects several Managers through an in-memory Network instead of real ports
+type Harness struct {
+	Network *Network
+	Timeout time.Duration // Time WaitForUp, WaitForDown and Expect wait before giving up
+	nodes   []*Node
+	nodesMU sync.Mutex
+}
+
+// Node A Manager running on the harness network
+type Node struct {
+	UUID     string
+	URL      string // URL other nodes use to reach this node's API
+	Manager  *longpoll.Manager
+	Inbound  *Faults // Faults applied to requests served by this node
+	Outbound *Faults // Faults applied to requests this node sends to its server peers
+	harness  *Harness
+
+	mu      sync.Mutex
+	online  map[string]bool
+	inbox   []Received
+	changed chan struct{} // Closed and replaced whenever online or inbox changes
+}
+
+// Received A message received by a Node
+type Received struct {
+	PeerUUID string
+	Message  longpoll.Message
+}
+
+// NewHarness Creates a new harness with an empty network
+func NewHarness() *Harness {
+	return &Harness{
+		Network: NewNetwork(),
+		Timeout: 5 * time.Second,
+	}
+}
+
+// NewPair Creates a harness with a "server" node and a "client" node that polls it
+func NewPair() (h *Harness, server *Node, client *Node, err error) {
+	h = NewHarness()
+	server, err = h.Add("server")
+	if err != nil {
+		h.Close()
+		return nil, nil, nil, err
+	}
+	client, err = h.Add("client")
+	if err != nil {
+		h.Close()
+		return nil, nil, nil, err
+	}
+	err = h.Connect(client, server)
+	if err != nil {
+		h.Close()
+		return nil, nil, nil, err
+	}
+	return h, server, client, nil
+}
+
+// Add Creates a Manager with short test timings and serves it on the network under its UUID
+func (h *Harness) Add(uuid string) (*Node, error) {
+	n := &Node{
+		UUID:     uuid,
+		URL:      "http://" + uuid + "/poll",
+		Inbound:  NewFaults(),
+		Outbound: NewFaults(),
+		harness:  h,
+		online:   make(map[string]bool),
+		changed:  make(chan struct{}),
+	}
+
+	// Create the manager with short timings, routing traffic through the faults and recording callbacks
+	m, err := longpoll.NewManager(
+		longpoll.WithUUID(uuid),
+		longpoll.WithPath("/poll"),
+		longpoll.WithPollLength(1*time.Second),
+		longpoll.WithDeadline(3*time.Second),
+		longpoll.WithPeerExpiry(5*time.Second),
+		longpoll.WithTransport(n.Outbound.Transport(h.Network.Transport())),
+		longpoll.WithMiddleware(n.Inbound.Middleware()),
+		longpoll.OnUp(func(peerUUID string) {
+			n.update(func() { n.online[peerUUID] = true })
+		}),
+		longpoll.OnDown(func(peerUUID string) {
+			n.update(func() { n.online[peerUUID] = false })
+		}),
+		longpoll.OnReceive(func(peerUUID string, msg longpoll.Message) {
+			n.update(func() { n.inbox = append(n.inbox, Received{PeerUUID: peerUUID, Message: msg}) })
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	n.Manager = m
+
+	// Serve on the in-memory network
+	ln, err := h.Network.Listen(uuid)
+	if err != nil {
+		m.Stop()
+		return nil, err
+	}
+	err = m.Serve(ln)
+	if err != nil {
+		ln.Close()
+		m.Stop()
+		return nil, err
+	}
+
+	h.nodesMU.Lock()
+	h.nodes = append(h.nodes, n)
+	h.nodesMU.Unlock()
+	return n, nil
+}
+
+// Connect Adds server as a server peer of client
+func (h *Harness) Connect(client *Node, server *Node) error {
+	return client.Manager.AddServerPeer(server.UUID, server.URL, nil, nil)
+}
+
+// Partition Blocks all requests between a and b until Heal is called
+func (h *Harness) Partition(a *Node, b *Node) {
+	a.Inbound.Partition(a.UUID, b.UUID)
+	b.Inbound.Partition(a.UUID, b.UUID)
+}
+
+// Heal Removes all partitions between nodes
+func (h *Harness) Heal() {
+	h.nodesMU.Lock()
+	defer h.nodesMU.Unlock()
+	for _, n := range h.nodes {
+		n.Inbound.Heal()
+	}
+}
+
+// Close Stops every node on the harness
+func (h *Harness) Close() {
+	h.nodesMU.Lock()
+	defer h.nodesMU.Unlock()
+	for _, n := range h.nodes {
+		n.Manager.Stop()
+	}
+	h.nodes = nil
+}
+
+// WaitForUp Waits until the node has seen the peer come online
+func (n *Node) WaitForUp(peerUUID string) error {
+	err := n.wait(func() bool { return n.online[peerUUID] })
+	if err != nil {
+		return errors.New(n.UUID + ": timed out waiting for " + peerUUID + " to come online")
+	}
+	return nil
+}
+
+// WaitForDown Waits until the node has seen the peer go offline
+func (n *Node) WaitForDown(peerUUID string) error {
+	err := n.wait(func() bool {
+		online, seen := n.online[peerUUID]
+		return seen && !online
+	})
+	if err != nil {
+		return errors.New(n.UUID + ": timed out waiting for " + peerUUID + " to go offline")
+	}
+	return nil
+}
+
+// Expect Waits for a message whose Data matches data as sent by Manager.Send and removes it from the inbox
+func (n *Node) Expect(data interface{}) (Received, error) {
+	want, err := json.Marshal(data)
+	if err != nil {
+		return Received{}, err
+	}
+
+	var found Received
+	err = n.wait(func() bool {
+		for i, r := range n.inbox {
+			if bytes.Equal(r.Message.Data, want) {
+				found = r
+				n.inbox = append(n.inbox[:i], n.inbox[i+1:]...)
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return Received{}, errors.New(n.UUID + ": timed out waiting for message " + string(want))
+	}
+	return found, nil
+}
+
+// Inbox Returns the messages received so far that have not been matched by Expect
+func (n *Node) Inbox() []Received {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	inbox := make([]Received, len(n.inbox))
+	copy(inbox, n.inbox)
+	return inbox
+}
+
+// Applies a change to the node state and wakes up any waiters
+func (n *Node) update(change func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	change()
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+// Waits until cond returns true or the harness timeout expires. cond is called with mu held
+func (n *Node) wait(cond func() bool) error {
+	timeout := time.After(n.harness.Timeout)
+	for {
+		n.mu.Lock()
+		if cond() {
+			n.mu.Unlock()
+			return nil
+		}
+		changed := n.changed
+		n.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout:
+			return errors.New("timed out")
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package longpolltest
+
+import (
+	"testing"
+	"time"
+)
+
+func newPair(t *testing.T) (*Harness, *Node, *Node) {
+	t.Helper()
+	h, server, client, err := NewPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+	if err := client.WaitForUp("server"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.WaitForUp("client"); err != nil {
+		t.Fatal(err)
+	}
+	return h, server, client
+}
+
+func TestPairRoundTrip(t *testing.T) {
+	_, server, client := newPair(t)
+	if err := server.Manager.Send("client", "hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Expect("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Manager.Send("server", "world", nil); err != nil {
+		t.Fatal(err)
+	}
+	r, err := server.Expect("world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.PeerUUID != "client" {
+		t.Fatalf("got message from %s, want client", r.PeerUUID)
+	}
+}
+
+func TestPartitionAndHeal(t *testing.T) {
+	h, server, client := newPair(t)
+	h.Partition(server, client)
+	if err := client.WaitForDown("server"); err != nil {
+		t.Fatal(err)
+	}
+	h.Heal()
+	if err := client.WaitForUp("server"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Manager.Send("client", "healed", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Expect("healed"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDropNextLosesMessage(t *testing.T) {
+	h, server, client := newPair(t)
+
+	// Queue a message while the client can't poll, so the first poll after healing carries it
+	h.Partition(server, client)
+	if err := client.WaitForDown("server"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Manager.Send("client", "lost", nil); err != nil {
+		t.Fatal(err)
+	}
+	server.Inbound.DropNext(1)
+	h.Heal()
+
+	h.Timeout = 3 * time.Second
+	if _, err := client.Expect("lost"); err == nil {
+		t.Fatal("dropped message was received")
+	}
+
+	// Later messages get through
+	if err := server.Manager.Send("client", "after", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Expect("after"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddDuplicateHost(t *testing.T) {
+	h := NewHarness()
+	defer h.Close()
+	if _, err := h.Add("server"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Add("server"); err == nil {
+		t.Fatal("second node with the same host added")
+	}
+}
@@ -0,0 +1,125 @@
+package longpolltest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+)
+
+// Network An in-memory network that connects listeners and transports by host name
+type Network struct {
+	listeners   map[string]*Listener
+	listenersMU sync.Mutex
+}
+
+// Listener An in-memory net.Listener registered on a Network
+type Listener struct {
+	host      string
+	network   *Network
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// NewNetwork Creates a new empty in-memory network
+func NewNetwork() *Network {
+	return &Network{
+		listeners: make(map[string]*Listener),
+	}
+}
+
+// Listen Registers a new listener for a host name eg: "server1"
+func (n *Network) Listen(host string) (*Listener, error) {
+	n.listenersMU.Lock()
+	defer n.listenersMU.Unlock()
+
+	// Check host is not taken
+	if _, exists := n.listeners[host]; exists {
+		return nil, errors.New("host already in use: " + host)
+	}
+
+	l := &Listener{
+		host:    host,
+		network: n,
+		conns:   make(chan net.Conn),
+		closed:  make(chan struct{}),
+	}
+	n.listeners[host] = l
+	return l, nil
+}
+
+// Dial Connects to the listener registered for the host in addr
+func (n *Network) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	// Strip the port if present
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	// Find the listener
+	n.listenersMU.Lock()
+	l, _ := n.listeners[host]
+	n.listenersMU.Unlock()
+	if l == nil {
+		return nil, refused(addr)
+	}
+
+	// Hand one end of the pipe to the listener
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, refused(addr)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Returns the error a real dial to a closed port returns, so callers can tell the request was never sent
+func refused(addr string) error {
+	return &net.OpError{Op: "dial", Net: "tcp", Addr: pipeAddr(addr), Err: syscall.ECONNREFUSED}
+}
+
+// Transport Returns a http.Transport that dials hosts on this network
+func (n *Network) Transport() *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return n.Dial(ctx, addr)
+		},
+	}
+}
+
+// Accept Waits for the next connection to the listener
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close Closes the listener and removes it from the network
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.network.listenersMU.Lock()
+		delete(l.network.listeners, l.host)
+		l.network.listenersMU.Unlock()
+	})
+	return nil
+}
+
+// Addr Returns the host name of the listener
+func (l *Listener) Addr() net.Addr {
+	return pipeAddr(l.host)
+}
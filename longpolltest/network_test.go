@@ -0,0 +1,65 @@
+package longpolltest
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lampy255/go-longpoll"
+)
+
+func TestDialRefused(t *testing.T) {
+	n := NewNetwork()
+	_, err := n.Dial(context.Background(), "nowhere:80")
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("got %v, want connection refused", err)
+	}
+}
+
+func TestPOSTFailsOverWhenRefused(t *testing.T) {
+	h := NewHarness()
+	defer h.Close()
+	server, err := h.Add("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := h.Add("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Answer polls without dialing so the refused URL stays healthy and is tried first by the send
+	client.Outbound.Inject(Fault{Kind: FaultStatus, Method: "GET", Status: 400})
+	events, stop := client.Manager.Events(64)
+	defer stop()
+
+	// The first URL has no listener
+	err = client.Manager.AddServerPeerWithConfig("server", longpoll.ServerPeerConfig{
+		URL:  "http://down/poll",
+		URLs: []string{server.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Manager.Send("server", "hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Expect("hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The send tried the refused URL first
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Type == longpoll.EventEndpointDown && e.URL == "http://down/poll" {
+				return
+			}
+		case <-timeout:
+			t.Fatal("the refused URL was not tried")
+		}
+	}
+}
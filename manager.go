@@ -1,10 +1,13 @@
 package longpoll
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"log"
-	"net/http/cookiejar"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
 	"strconv"
 	"time"
 
@@ -14,21 +17,32 @@ import (
 
 // NewDefaultManager Creates a new LongPoll Manager with default settings
 func NewDefaultManager() *Manager {
-	jar, err := cookiejar.New(nil)
-	if err != nil {
-		log.Fatalf("Failed to create cookie jar: %v", err)
-	}
-
 	m := &Manager{
 		UUID:               uuid.New().String(),
-		cookieJar:          jar,
 		peers:              make(map[string]*Peer, 255),
 		API_Port:           8080,
 		API_Path:           "/poll",
 		PollLength:         10 * time.Second,
+		MinPollLength:      1 * time.Second,
+		MaxPollLength:      60 * time.Second,
 		PeerExpiry:         30 * time.Second,
 		Deadline:           20 * time.Second,
 		OutboundBufferSize: 150,
+
+		CircuitBreakerThreshold: 5,
+		CircuitBreakerTimeout:   30 * time.Second,
+
+		InboundLimits: InboundLimits{
+			MaxBodySize:        4 << 20,
+			MaxAttributes:      64,
+			MaxAttributeLength: 1024,
+			MaxMessageIDLength: 128,
+			MaxUUIDLength:      128,
+		},
+		stop:    make(chan struct{}),
+		limiter: newRateLimiter(),
+		metrics: newMetricsRegistry(),
+		events:  newEventBus(),
 	}
 	return m
 }
@@ -36,86 +50,294 @@ func NewDefaultManager() *Manager {
 // Start Starts the LongPoll Manager API and garbage collection
 func (m *Manager) Start() error {
 	// Dummy checks
-	if m.API_Path == "" {
-		return errors.New("API_Path is required")
+	err := m.validate()
+	if err != nil {
+		return err
 	}
-	if m.PollLength < 1*time.Second {
-		return errors.New("PollLength must be at least 1 second")
+
+	// Listen on the API port
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(m.API_Port))
+	if err != nil {
+		return errors.New("failed to start API server: " + err.Error())
 	}
-	if m.PeerExpiry < 1*time.Second {
-		return errors.New("PeerExpiry must be at least 1 second")
+
+	return m.Serve(ln)
+}
+
+// Serve Starts the LongPoll Manager API on an existing listener and starts garbage collection
+func (m *Manager) Serve(ln net.Listener) error {
+	// Dummy checks
+	err := m.validate()
+	if err != nil {
+		return err
 	}
-	if m.Deadline < 1*time.Second {
-		return errors.New("deadline must be at least 1 second")
+
+	select {
+	case <-m.stop:
+		return errors.New("manager is stopped, create a new one")
+	default:
 	}
 
-	// Convert port to string
-	port := strconv.Itoa(m.API_Port)
+	// Serve HTTPS if configured
+	ln, err = m.tlsListener(ln)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+
+	// Listen on the admin port if applicable
+	var adminLn net.Listener
+	if m.AdminPort != 0 {
+		adminLn, err = net.Listen("tcp", ":"+strconv.Itoa(m.AdminPort))
+		if err != nil {
+			ln.Close()
+			return errors.New("failed to start admin server: " + err.Error())
+		}
+	}
 
-	// Start Garbage Collection
+	// Start Garbage Collection now every listener is open
 	go func() {
 		for {
-			m.garbageCollectPeers()
-			time.Sleep(10 * time.Second)
+			select {
+			case <-m.stop:
+				return
+			case <-time.After(10 * time.Second):
+				m.garbageCollectPeers()
+			}
+		}
+	}()
+
+	// Start the server
+	m.serverMU.Lock()
+	m.server = &http.Server{Handler: m.Handler()}
+	server := m.server
+	if adminLn != nil {
+		m.adminServer = &http.Server{Handler: m.AdminHandler()}
+	}
+	adminServer := m.adminServer
+	m.serverMU.Unlock()
+	m.serving.Store(true)
+	go func() {
+		err := server.Serve(ln)
+		m.serving.Store(false)
+		if err != nil && err != http.ErrServerClosed {
+			m.emit(Event{Type: EventError, Reason: "serve_api", Err: err})
 		}
 	}()
 
+	// Start the admin server
+	if adminLn != nil {
+		go func() {
+			err := adminServer.Serve(adminLn)
+			if err != nil && err != http.ErrServerClosed {
+				m.emit(Event{Type: EventError, Reason: "serve_admin", Err: err})
+			}
+		}()
+	}
+	return nil
+}
+
+// Handler Returns the LongPoll Manager API as a http.Handler
+func (m *Manager) Handler() http.Handler {
 	// Create API server
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
 
+	// Count responses
+	r.Use(m.metricsMiddleware)
+
 	// Apply Middleware if applicable
 	if m.API_Middleware != nil {
-		r.Use(*m.API_Middleware)
+		r.Use(m.API_Middleware)
 	}
 
 	// Add routes
 	r.GET(m.API_Path, m.handleGET)
 	r.POST(m.API_Path, m.handlePOST)
+	if m.MetricsPath != "" {
+		r.GET(m.MetricsPath, gin.WrapH(m.MetricsHandler()))
+	}
+	if m.HealthChecks {
+		m.healthRoutes(r)
+	}
+	if m.AdminPath != "" {
+		admin := r.Group(m.AdminPath)
+		if m.AdminMiddleware != nil {
+			admin.Use(m.AdminMiddleware)
+		}
+		m.adminRoutes(admin)
+	}
+	return r
+}
 
-	// Start the server
-	go func() {
-		err := r.Run(":" + port)
+// Stop Stops the LongPoll Manager API, garbage collection and all server peer poll routines.
+// Stop is final: Start and Serve fail on a stopped Manager, create a new one to serve again
+func (m *Manager) Stop() error {
+	// Stop background routines
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+
+	// Remove all peers
+	m.peersMU.Lock()
+	for _, peer := range m.peers {
+		m.removePeer(peer)
+	}
+	m.peersMU.Unlock()
+
+	// Close the servers
+	m.serverMU.Lock()
+	defer m.serverMU.Unlock()
+	if m.adminServer != nil {
+		m.adminServer.Close()
+	}
+	if m.server != nil {
+		return m.server.Close()
+	}
+	return nil
+}
+
+// Checks the manager settings are valid
+func (m *Manager) validate() error {
+	if m.API_Path == "" {
+		return errors.New("API_Path is required")
+	}
+	if m.PollLength < 1*time.Second {
+		return errors.New("PollLength must be at least 1 second")
+	}
+	if m.PeerExpiry < 1*time.Second {
+		return errors.New("PeerExpiry must be at least 1 second")
+	}
+	if m.Deadline < 1*time.Second {
+		return errors.New("deadline must be at least 1 second")
+	}
+	if m.MinPollLength < 0 || m.MaxPollLength < 0 || (m.MaxPollLength > 0 && m.MaxPollLength < m.MinPollLength) {
+		return errors.New("MaxPollLength must not be less than MinPollLength")
+	}
+	if a, ok := m.Authenticator.(*SessionAuthenticator); ok && len(a.Secret) == 0 {
+		return errors.New("SessionAuthenticator requires a Secret")
+	}
+	if m.TransportConfig != nil {
+		err := m.TransportConfig.Validate()
 		if err != nil {
-			log.Fatalf("Failed to start API server: %v", err)
+			return errors.New("TransportConfig: " + err.Error())
 		}
-	}()
+	}
+	if m.CircuitBreakerThreshold < 0 {
+		return errors.New("CircuitBreakerThreshold must not be negative")
+	}
+	if m.CircuitBreakerThreshold > 0 && m.CircuitBreakerTimeout <= 0 {
+		return errors.New("CircuitBreakerTimeout must be positive")
+	}
+	if m.API_Port < 0 || m.API_Port > 65535 {
+		return errors.New("API_Port must be between 0 and 65535")
+	}
+	if m.AdminPort < 0 || m.AdminPort > 65535 {
+		return errors.New("AdminPort must be between 0 and 65535")
+	}
+	if (m.AdminPath != "" || m.AdminPort != 0) && m.AdminMiddleware == nil {
+		// The admin API can delete peers and read their queues, never serve it unprotected by accident
+		return errors.New("AdminPath and AdminPort require AdminMiddleware")
+	}
+	if m.OutboundBufferSize < 0 {
+		return errors.New("OutboundBufferSize must not be negative")
+	}
+	if m.MaxPeers < 0 || m.MaxPeersPerIP < 0 {
+		return errors.New("MaxPeers and MaxPeersPerIP must not be negative")
+	}
+	err := m.RateLimits.Validate()
+	if err != nil {
+		return errors.New("RateLimits: " + err.Error())
+	}
+	if (m.TLSCertFile == "") != (m.TLSKeyFile == "") {
+		return errors.New("TLSCertFile and TLSKeyFile must be set together")
+	}
+	if m.KeyStore == nil && (m.SignMessages || m.EncryptMessages || m.RequireSignatures || m.RequireEncryption) {
+		return errors.New("KeyStore is required to sign or encrypt messages")
+	}
+
+	// Parse IP lists
+	allowed, err := parseNetworks(m.AllowedIPs)
+	if err != nil {
+		return errors.New("AllowedIPs: " + err.Error())
+	}
+	denied, err := parseNetworks(m.DeniedIPs)
+	if err != nil {
+		return errors.New("DeniedIPs: " + err.Error())
+	}
+	m.settingsMU.Lock()
+	m.allowedNets = allowed
+	m.deniedNets = denied
+	m.settingsMU.Unlock()
 	return nil
 }
 
 // AddServerPeer Adds a server peer to the LongPoll Manager
 func (m *Manager) AddServerPeer(uuid string, url string, headers map[string]string, stickyAttributes map[string]string) error {
+	return m.AddServerPeerWithConfig(uuid, ServerPeerConfig{
+		URL:              url,
+		Headers:          headers,
+		StickyAttributes: stickyAttributes,
+	})
+}
+
+// AddServerPeerWithConfig Adds a server peer to the LongPoll Manager with extra settings eg: TLS
+func (m *Manager) AddServerPeerWithConfig(uuid string, config ServerPeerConfig) error {
 	// Check uuid is not empty
 	if uuid == "" {
 		return errors.New("uuid is required")
 	}
 
 	// Check server URL is not empty
-	if url == "" {
+	urls := config.urls()
+	if len(urls) == 0 {
 		return errors.New("server URL is required")
 	}
+	err := config.Strategy.Validate()
+	if err != nil {
+		return err
+	}
+
+	// Check the settings overrides
+	err = config.Settings.Validate()
+	if err != nil {
+		return err
+	}
 
 	// Does the peer already exist?
 	if m.PeerExists(uuid) {
 		return errors.New("peer already exists")
 	}
 
+	// Create the peer client
+	client, err := m.peerClient(config, nil)
+	if err != nil {
+		return err
+	}
+
 	// Create a new Peer
 	lpp := &Peer{
 		UUID:             uuid,
 		IsServer:         true,
-		ServerURL:        url,
-		Headers:          headers,
-		StickyAttrbitues: stickyAttributes,
-		upCallback:       m.UpCallback,
-		downCallback:     m.DownCallback,
-		receiveCallback:  m.ReceiveCallback,
+		ServerURL:        urls[0],
+		endpoints:        newEndpointSet(urls, config.Strategy),
+		Headers:          config.Headers,
+		StickyAttrbitues: config.StickyAttributes,
+		client:           client,
+		transportConfig:  config.Transport,
+		tlsConfig:        config.TLS,
+		settings:         config.Settings,
+		pollDuration:     config.PollDuration,
+		redirectHosts:    config.RedirectHosts,
+		required:         config.Required,
+		removed:          make(chan struct{}),
 	}
 
 	// Store the peer
 	m.peersMU.Lock()
 	m.peers[uuid] = lpp
 	m.peersMU.Unlock()
+	m.emit(Event{Type: EventPeerCreated, PeerUUID: uuid})
 
 	// Start poll routine
 	go func() {
@@ -124,15 +346,27 @@ func (m *Manager) AddServerPeer(uuid string, url string, headers map[string]stri
 			m.peersMU.RLock()
 			Peer, _ := m.peers[uuid]
 			m.peersMU.RUnlock()
-			if Peer == nil {
+			if Peer != lpp {
 				// Quit the routine if the peer has been deleted
 				return
 			}
 
 			// Send Poll (this will block until a message is received)
-			err := Peer.pollGET(m.Deadline, m.UUID, m.cookieJar)
-			if err != nil {
-				time.Sleep(m.PollLength)
+			err := Peer.pollGET(m)
+			Peer.recordPoll(err)
+
+			// Follow the server's Retry-After hint, or wait before retrying a failed poll
+			delay := Peer.retryAfter
+			if delay == 0 && err != nil {
+				delay = Peer.pollLength(m)
+			}
+			var circuitErr *CircuitOpenError
+			if errors.As(err, &circuitErr) {
+				// Wait for the circuit breaker to let a request through
+				delay = time.Until(circuitErr.RetryAt)
+			}
+			if !Peer.waitToPoll(m, jitter(delay)) {
+				return
 			}
 		}
 	}()
@@ -149,16 +383,22 @@ func (m *Manager) DeletePeer(uuid string) error {
 		return errors.New("peer not found")
 	}
 
-	// Close channel if it exists
-	if peer.Ch != nil {
-		close(peer.Ch)
-	}
-
 	// Delete the peer
-	delete(m.peers, uuid)
+	m.removePeer(peer)
+	if peer.Online {
+		m.emit(Event{Type: EventPeerOffline, PeerUUID: uuid, IPAddr: peer.getIPAddr(), Reason: ReasonDeleted})
+	}
 	return nil
 }
 
+// Deletes a peer and ends its in-flight poll. Must be called with peersMU held
+func (m *Manager) removePeer(peer *Peer) {
+	delete(m.peers, peer.UUID)
+	peer.removeOnce.Do(func() {
+		close(peer.removed)
+	})
+}
+
 // PeerExists Checks if a peer exists. This function locks peersMU!
 func (m *Manager) PeerExists(uuid string) bool {
 	m.peersMU.RLock()
@@ -169,6 +409,12 @@ func (m *Manager) PeerExists(uuid string) bool {
 
 // AddTopic Adds a topic to a peer
 func (m *Manager) AddTopic(uuid string, topic string) error {
+	// Check the peer may subscribe
+	err := m.checkSubscribe(uuid, topic)
+	if err != nil {
+		return err
+	}
+
 	m.peersMU.Lock()
 	defer m.peersMU.Unlock()
 	peer, _ := m.peers[uuid]
@@ -213,6 +459,14 @@ func (m *Manager) GetTopics(uuid string) ([]string, error) {
 
 // SetTopics Sets the topics of a peer
 func (m *Manager) SetTopics(uuid string, topics []string) error {
+	// Check the peer may subscribe to every topic
+	for _, topic := range topics {
+		err := m.checkSubscribe(uuid, topic)
+		if err != nil {
+			return err
+		}
+	}
+
 	m.peersMU.Lock()
 	defer m.peersMU.Unlock()
 
@@ -234,7 +488,7 @@ func (m *Manager) GetPeerIP(uuid string) (string, error) {
 		return "", errors.New("peer not found")
 	}
 
-	return peer.ipAddr, nil
+	return peer.getIPAddr(), nil
 }
 
 // SetPeerStickyAttributes Sets the sticky attributes of a peer
@@ -246,12 +500,17 @@ func (m *Manager) SetPeerStickyAttributes(peerUUID string, attributes map[string
 		return errors.New("peer not found")
 	}
 
-	peer.StickyAttrbitues = attributes
+	peer.setStickyAttributes(attributes)
 	return nil
 }
 
 // Send Sends a message to a peer. Locks Mutex!
 func (m *Manager) Send(peerUUID string, data interface{}, attributes map[string]string) error {
+	return m.SendContext(context.Background(), peerUUID, data, attributes)
+}
+
+// SendContext Sends a message to a peer, propagating the trace context in ctx. Locks Mutex!
+func (m *Manager) SendContext(ctx context.Context, peerUUID string, data interface{}, attributes map[string]string) (err error) {
 	// Marshal the data
 	var dataBytes []byte
 	if data != nil {
@@ -281,33 +540,37 @@ func (m *Manager) Send(peerUUID string, data interface{}, attributes map[string]
 		return errors.New("failed to send message to " + peerUUID + ": peer not found")
 	}
 
-	// Apply sticky attributes
-	for k, v := range peer.StickyAttrbitues {
-		message.Attributes[k] = v
+	// Start a span
+	ctx, span := m.startSpan(ctx, "longpoll.send", map[string]string{"peer": peerUUID, "message_id": message.MessageID})
+	defer func() {
+		span.End(err)
+	}()
+
+	// Apply sticky attributes and trace context
+	message.Attributes = injectTrace(ctx, mergeAttributes(message.Attributes, peer.stickyAttributes()))
+
+	// Sign and encrypt the message
+	message, err = m.sealMessage(peerUUID, message)
+	if err != nil {
+		return errors.New("failed to send message to " + peerUUID + ": " + err.Error())
 	}
 
-	// Check if the peer is a server
-	if peer.IsServer {
-		// Send via POST
-		err := peer.pollPOST(message, m.UUID, m.Deadline, m.cookieJar)
-		if err != nil {
-			return errors.New("failed to send message to " + peerUUID + ": " + err.Error())
-		} else {
-			return nil
-		}
-	} else {
-		// Send via channel
-		select {
-		case peer.Ch <- message:
-			return nil
-		case <-time.After(m.Deadline):
-			return errors.New("failed to send message to peer: " + peerUUID + ": deadline exceeded")
-		}
+	// Deliver the message
+	err = m.deliver(ctx, peer, message, "send")
+	if err != nil {
+		return fmt.Errorf("failed to send message to %s: %w", peerUUID, err)
 	}
+	return nil
 }
 
 // Forward Forwards an existing message to a peer. Locks Mutex!
 func (m *Manager) Forward(peerUUID string, message Message) error {
+	return m.ForwardContext(context.Background(), peerUUID, message)
+}
+
+// ForwardContext Forwards an existing message to a peer, propagating the trace context in ctx.
+// Without trace context in ctx the message keeps its own traceparent attribute. Locks Mutex!
+func (m *Manager) ForwardContext(ctx context.Context, peerUUID string, message Message) (err error) {
 	// Retrieve the peer
 	m.peersMU.RLock()
 	peer, _ := m.peers[peerUUID]
@@ -316,33 +579,81 @@ func (m *Manager) Forward(peerUUID string, message Message) error {
 		return errors.New("failed to forward message to " + peerUUID + ": peer not found")
 	}
 
-	// Apply sticky attributes
-	for k, v := range peer.StickyAttrbitues {
-		message.Attributes[k] = v
+	// Continue the trace of the message if ctx has none
+	if _, ok := SpanContextFromContext(ctx); !ok {
+		ctx = extractTrace(ctx, message.Attributes)
+	}
+
+	// Start a span
+	ctx, span := m.startSpan(ctx, "longpoll.forward", map[string]string{"peer": peerUUID, "message_id": message.MessageID})
+	defer func() {
+		span.End(err)
+	}()
+
+	// Apply sticky attributes and trace context
+	message.Attributes = injectTrace(ctx, mergeAttributes(message.Attributes, peer.stickyAttributes()))
+
+	// Sign and encrypt the message
+	message, err = m.sealMessage(peerUUID, message)
+	if err != nil {
+		return errors.New("failed to forward message to " + peerUUID + ": " + err.Error())
+	}
+
+	// Deliver the message
+	err = m.deliver(ctx, peer, message, "forward")
+	if err != nil {
+		return fmt.Errorf("failed to forward message to %s: %w", peerUUID, err)
 	}
+	return nil
+}
 
+// Delivers a message to a server peer via POST or to a client peer's channel
+func (m *Manager) deliver(ctx context.Context, peer *Peer, message Message, op string) error {
 	// Check if the peer is a server
 	if peer.IsServer {
 		// Send via POST
-		err := peer.pollPOST(message, m.UUID, m.Deadline, m.cookieJar)
+		err := peer.pollPOST(ctx, m, message)
 		if err != nil {
-			return errors.New("failed to forward message to " + peerUUID + ": " + err.Error())
-		} else {
+			m.messageDropped(peer.UUID, message.MessageID, op, postFailedReason(err), err)
+			return err
+		}
+		m.countSent(op)
+		return nil
+	}
+
+	// Send via channel, waiting for space until the deadline
+	deadline := time.NewTimer(peer.deadline(m))
+	defer deadline.Stop()
+	for {
+		queued, space := peer.enqueue(message)
+		if queued {
+			m.countSent(op)
 			return nil
 		}
-	} else {
-		// Send via channel
 		select {
-		case peer.Ch <- message:
-			return nil
-		case <-time.After(m.Deadline):
-			return errors.New("failed to forward message to " + peerUUID + ": deadline exceeded")
+		case <-space:
+		case <-deadline.C:
+			err := errors.New("deadline exceeded")
+			m.messageDropped(peer.UUID, message.MessageID, op, "deadline", err)
+			return err
+		case <-ctx.Done():
+			m.messageDropped(peer.UUID, message.MessageID, op, "cancelled", ctx.Err())
+			return ctx.Err()
+		case <-peer.removed:
+			err := errors.New("peer removed")
+			m.messageDropped(peer.UUID, message.MessageID, op, "peer_removed", err)
+			return err
 		}
 	}
 }
 
 // FanOut Sends a message to all peers
 func (m *Manager) FanOut(data interface{}, attributes map[string]string) error {
+	return m.FanOutContext(context.Background(), data, attributes)
+}
+
+// FanOutContext Sends a message to all peers, propagating the trace context in ctx
+func (m *Manager) FanOutContext(ctx context.Context, data interface{}, attributes map[string]string) error {
 	// Marshal the data
 	var dataBytes []byte
 	if data != nil {
@@ -356,58 +667,64 @@ func (m *Manager) FanOut(data interface{}, attributes map[string]string) error {
 		dataBytes = []byte{}
 	}
 
-	// Send the message to all peers
-	m.peersMU.Lock()
-	defer m.peersMU.Unlock()
-	for _, peer := range m.peers {
-		// Skip peers that are offline
-		if !peer.Online {
-			continue
-		}
+	// Start a span
+	ctx, span := m.startSpan(ctx, "longpoll.fanout", nil)
+	defer span.End(nil)
+	attributes = injectTrace(ctx, attributes)
 
+	// Send the message to all peers
+	recipients := 0
+	for _, peer := range m.fanOutTargets(func(peer *Peer) bool { return true }) {
 		// Create a new message
 		message := Message{
 			Data:        dataBytes,
-			Attributes:  attributes,
 			MessageID:   uuid.New().String(),
 			PublishTime: time.Now(),
 		}
 
 		// Apply sticky attributes
-		for k, v := range peer.StickyAttrbitues {
-			message.Attributes[k] = v
+		message.Attributes = mergeAttributes(attributes, peer.stickyAttributes())
+
+		// Sign and encrypt the message
+		message, err := m.sealMessage(peer.UUID, message)
+		if err != nil {
+			m.messageDropped(peer.UUID, message.MessageID, "fanout", "seal_failed", err)
+			continue
 		}
+		recipients++
 
 		// Check if the peer is a server
 		if peer.IsServer {
 			// Send via POST
-			err := peer.pollPOST(message, m.UUID, m.Deadline, m.cookieJar)
+			err := peer.pollPOST(ctx, m, message)
 			if err != nil {
-				log.Println("failed to FanOut message to peer: " + peer.ipAddr + " - " + err.Error())
+				m.messageDropped(peer.UUID, message.MessageID, "fanout", postFailedReason(err), err)
+			} else {
+				m.countSent("fanout")
 			}
 		} else {
 			// Send the message to the peers channel
 			go func() {
-				select {
-				case peer.Ch <- message:
-					return
-				case <-time.After(m.Deadline):
-					log.Println("failed to FanOut message to peer:", peer.ipAddr, "- deadline exceeded")
-					return
-				default:
-					size := strconv.Itoa(len(peer.Ch))
-					log.Println("failed to FanOut message to peer:", peer.ipAddr, "- outbound buffer full ("+size+")")
+				if queued, _ := peer.enqueue(message); queued {
+					m.countSent("fanout")
 					return
 				}
+				m.messageDropped(peer.UUID, message.MessageID, "fanout", "buffer_full", errors.New("outbound buffer full ("+strconv.Itoa(len(peer.Ch))+")"))
 			}()
 		}
 	}
 
+	m.metrics.observe("longpoll_fanout_size", metricLabels("op", "fanout"), float64(recipients))
 	return nil
 }
 
 // FanOutSubscribers Sends a message to all peers subscribed to a given topic
 func (m *Manager) FanOutSubscribers(data interface{}, attributes map[string]string, topic string) error {
+	return m.FanOutSubscribersContext(context.Background(), data, attributes, topic)
+}
+
+// FanOutSubscribersContext Sends a message to all peers subscribed to a given topic, propagating the trace context in ctx
+func (m *Manager) FanOutSubscribersContext(ctx context.Context, data interface{}, attributes map[string]string, topic string) error {
 	// Marshal the data
 	var dataBytes []byte
 	if data != nil {
@@ -421,58 +738,76 @@ func (m *Manager) FanOutSubscribers(data interface{}, attributes map[string]stri
 		dataBytes = []byte{}
 	}
 
-	// Send the message to all subscribers
-	m.peersMU.Lock()
-	defer m.peersMU.Unlock()
-	for _, peer := range m.peers {
-		// Skip peers that are offline
-		if !peer.Online {
-			continue
-		}
+	// Start a span
+	ctx, span := m.startSpan(ctx, "longpoll.fanout_subscribers", map[string]string{"topic": topic})
+	defer span.End(nil)
+	attributes = injectTrace(ctx, attributes)
 
+	// Send the message to all subscribers
+	recipients := 0
+	for _, peer := range m.fanOutTargets(func(peer *Peer) bool { return peer.subscribed(topic) }) {
 		// Create a new message
 		message := Message{
 			Data:        dataBytes,
-			Attributes:  attributes,
 			MessageID:   uuid.New().String(),
 			PublishTime: time.Now(),
 		}
 
 		// Apply sticky attributes
-		for k, v := range peer.StickyAttrbitues {
-			message.Attributes[k] = v
+		message.Attributes = mergeAttributes(attributes, peer.stickyAttributes())
+
+		// Sign and encrypt the message
+		message, err := m.sealMessage(peer.UUID, message)
+		if err != nil {
+			m.messageDropped(peer.UUID, message.MessageID, "fanout_subscribers", "seal_failed", err)
+			continue
 		}
+		recipients++
 
-		// Check if the peer is subscribed to the topic
-		for _, t := range peer.Topics {
-			if t == topic {
-				// Check if the peer is a server
-				if peer.IsServer {
-					// Send via POST
-					err := peer.pollPOST(message, m.UUID, m.Deadline, m.cookieJar)
-					if err != nil {
-						log.Println("failed to FanOut subscriber message to peer: " + peer.ipAddr + " - " + err.Error())
-					}
-				} else {
-					go func() {
-						// Send via channel
-						select {
-						case peer.Ch <- message:
-							return
-						case <-time.After(m.Deadline):
-							log.Println("failed to FanOut subscriber message to peer:", peer.ipAddr, "- deadline exceeded")
-							return
-						default:
-							size := strconv.Itoa(len(peer.Ch))
-							log.Println("failed to FanOut subscriber message to peer:", peer.ipAddr, "- outbound buffer full ("+size+")")
-							return
-						}
-					}()
-				}
-				break
+		// Check if the peer is a server
+		if peer.IsServer {
+			// Send via POST
+			err := peer.pollPOST(ctx, m, message)
+			if err != nil {
+				m.messageDropped(peer.UUID, message.MessageID, "fanout_subscribers", postFailedReason(err), err)
+			} else {
+				m.countSent("fanout_subscribers")
 			}
+		} else {
+			go func() {
+				// Send via channel
+				if queued, _ := peer.enqueue(message); queued {
+					m.countSent("fanout_subscribers")
+					return
+				}
+				m.messageDropped(peer.UUID, message.MessageID, "fanout_subscribers", "buffer_full", errors.New("outbound buffer full ("+strconv.Itoa(len(peer.Ch))+")"))
+			}()
 		}
 	}
 
+	m.metrics.observe("longpoll_fanout_size", metricLabels("op", "fanout_subscribers"), float64(recipients))
 	return nil
 }
+
+// Returns the online peers matching filter. The peers are copied under peersMU so POSTs to server peers
+// don't hold it while markOnline and markOffline wait for it
+func (m *Manager) fanOutTargets(filter func(peer *Peer) bool) []*Peer {
+	m.peersMU.RLock()
+	defer m.peersMU.RUnlock()
+	peers := make([]*Peer, 0, len(m.peers))
+	for _, peer := range m.peers {
+		// Skip peers that are offline
+		if peer.Online && filter(peer) {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// Returns Manager.Logger or the default logger
+func (m *Manager) logger() *slog.Logger {
+	if m.Logger != nil {
+		return m.Logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,110 @@
+package longpoll
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServeAdminListenFailure(t *testing.T) {
+	// Hold the admin port so the admin listener fails
+	busy, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	m, err := NewManager(WithAdminPort(busy.Addr().(*net.TCPAddr).Port), WithAdminMiddleware(func(c *gin.Context) { c.Next() }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Serve(ln)
+	if err == nil {
+		t.Fatal("Serve succeeded with the admin port in use")
+	}
+
+	// The API listener is closed
+	_, err = ln.Accept()
+	if !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("API listener still open: %v", err)
+	}
+}
+
+func TestServeAfterStop(t *testing.T) {
+	m, err := NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Stop()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if err := m.Serve(ln); err == nil {
+		t.Fatal("Serve succeeded on a stopped manager")
+	}
+}
+
+func TestFanOutDoesNotHoldPeersLock(t *testing.T) {
+	// A server whose POSTs block until released
+	posted := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			posted <- struct{}{}
+			<-release
+		} else {
+			time.Sleep(10 * time.Millisecond)
+		}
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	m, err := NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	err = m.AddServerPeerWithConfig("server", ServerPeerConfig{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddTopic("server", "topic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return m.Health().ServerPeers[0].Online })
+
+	for _, fanOut := range []func() error{
+		func() error { return m.FanOut("hello", nil) },
+		func() error { return m.FanOutSubscribers("hello", nil, "topic") },
+	} {
+		go fanOut()
+		<-posted
+
+		// The peers lock is free while the POST is in flight
+		locked := make(chan struct{})
+		go func() {
+			m.peersMU.Lock()
+			m.peersMU.Unlock()
+			close(locked)
+		}()
+		select {
+		case <-locked:
+		case <-time.After(5 * time.Second):
+			t.Fatal("peersMU held during the POST")
+		}
+		release <- struct{}{}
+	}
+}
@@ -0,0 +1,247 @@
+package longpoll
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Histogram buckets in seconds
+var (
+	pollWaitBuckets = []float64{0.01, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60}
+	latencyBuckets  = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	fanOutBuckets   = []float64{0, 1, 5, 10, 50, 100, 500, 1000, 5000}
+)
+
+// Collects counters and histograms for the Prometheus text format
+type metricsRegistry struct {
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	kind    string // counter or histogram
+	help    string
+	buckets []float64
+	values  map[string]*metricValue // Keyed by formatted labels
+}
+
+type metricValue struct {
+	value   float64  // Counter value or histogram sum
+	count   uint64   // Histogram observations
+	buckets []uint64 // Histogram bucket counts
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	r := &metricsRegistry{
+		series: make(map[string]*metricSeries),
+	}
+
+	// Server side
+	r.register("longpoll_http_responses_total", "counter", "Responses from the poll API by method and status code", nil)
+	r.register("longpoll_poll_wait_seconds", "histogram", "Time client peer polls waited before replying", pollWaitBuckets)
+	r.register("longpoll_messages_sent_total", "counter", "Messages delivered to a peer queue or server peer", nil)
+	r.register("longpoll_messages_dropped_total", "counter", "Messages that could not be delivered by reason", nil)
+	r.register("longpoll_messages_expired_total", "counter", "Messages discarded from the queues of expired peers", nil)
+	r.register("longpoll_peers_expired_total", "counter", "Client peers removed by garbage collection", nil)
+	r.register("longpoll_events_dropped_total", "counter", "Events discarded because a listener queue was full", nil)
+	r.register("longpoll_fanout_size", "histogram", "Number of peers a FanOut or FanOutSubscribers message was sent to", fanOutBuckets)
+
+	// Server peers
+	r.register("longpoll_server_peer_request_seconds", "histogram", "Duration of requests to server peers", latencyBuckets)
+	r.register("longpoll_server_peer_errors_total", "counter", "Failed requests to server peers", nil)
+	r.register("longpoll_server_peer_failovers_total", "counter", "Requests to server peers retried on another URL", nil)
+	r.register("longpoll_circuit_transitions_total", "counter", "Server peer circuit breaker state changes by new state", nil)
+	return r
+}
+
+func (r *metricsRegistry) register(name string, kind string, help string, buckets []float64) {
+	r.series[name] = &metricSeries{
+		kind:    kind,
+		help:    help,
+		buckets: buckets,
+		values:  make(map[string]*metricValue),
+	}
+}
+
+// Gets or creates the value for a series and labels. Must be called with mu held
+func (r *metricsRegistry) value(name string, labels string) *metricValue {
+	s := r.series[name]
+	v, _ := s.values[labels]
+	if v == nil {
+		v = &metricValue{}
+		if s.kind == "histogram" {
+			v.buckets = make([]uint64, len(s.buckets))
+		}
+		s.values[labels] = v
+	}
+	return v
+}
+
+// Adds to a counter
+func (r *metricsRegistry) add(name string, labels string, delta float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.value(name, labels).value += delta
+}
+
+// Records a histogram observation
+func (r *metricsRegistry) observe(name string, labels string, x float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.series[name]
+	v := r.value(name, labels)
+	v.value += x
+	v.count++
+	for i, upper := range s.buckets {
+		if x <= upper {
+			v.buckets[i]++
+		}
+	}
+}
+
+// Writes all series in the Prometheus text format
+func (r *metricsRegistry) write(sb *strings.Builder) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.series))
+	for name := range r.series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := r.series[name]
+		writeHeader(sb, name, s.kind, s.help)
+
+		keys := make([]string, 0, len(s.values))
+		for labels := range s.values {
+			keys = append(keys, labels)
+		}
+		sort.Strings(keys)
+
+		for _, labels := range keys {
+			v := s.values[labels]
+			if s.kind == "counter" {
+				writeSample(sb, name, labels, v.value)
+				continue
+			}
+			for i, upper := range s.buckets {
+				writeSample(sb, name+"_bucket", joinLabels(labels, metricLabels("le", formatFloat(upper))), float64(v.buckets[i]))
+			}
+			writeSample(sb, name+"_bucket", joinLabels(labels, metricLabels("le", "+Inf")), float64(v.count))
+			writeSample(sb, name+"_sum", labels, v.value)
+			writeSample(sb, name+"_count", labels, float64(v.count))
+		}
+	}
+}
+
+// MetricsHandler Returns a http.Handler serving the manager metrics in the Prometheus text format
+func (m *Manager) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var sb strings.Builder
+
+		// Peer gauges
+		online := map[bool]map[bool]int{true: {}, false: {}}
+		var depths []string
+		m.peersMU.RLock()
+		for _, peer := range m.peers {
+			online[peer.IsServer][peer.Online]++
+			if !peer.IsServer && peer.Ch != nil {
+				depths = append(depths, metricLabels("peer", peer.UUID)+" "+strconv.Itoa(len(peer.Ch)))
+			}
+		}
+		m.peersMU.RUnlock()
+
+		writeHeader(&sb, "longpoll_peers", "gauge", "Known peers by kind and online state")
+		for _, server := range []bool{false, true} {
+			kind := "client"
+			if server {
+				kind = "server"
+			}
+			for _, state := range []bool{false, true} {
+				writeSample(&sb, "longpoll_peers", metricLabels("kind", kind, "online", strconv.FormatBool(state)), float64(online[server][state]))
+			}
+		}
+
+		writeHeader(&sb, "longpoll_peer_queue_depth", "gauge", "Messages waiting in the outbound queue of each client peer")
+		sort.Strings(depths)
+		for _, depth := range depths {
+			sb.WriteString("longpoll_peer_queue_depth" + depth + "\n")
+		}
+
+		// Counters and histograms
+		m.metrics.write(&sb)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write([]byte(sb.String()))
+	})
+}
+
+// Counts API responses by method and status code
+func (m *Manager) metricsMiddleware(c *gin.Context) {
+	c.Next()
+	m.metrics.add("longpoll_http_responses_total", metricLabels("method", c.Request.Method, "code", strconv.Itoa(c.Writer.Status())), 1)
+}
+
+// Records the duration and outcome of a request to a server peer
+func (m *Manager) observeServerRequest(peerUUID string, method string, start time.Time, err error) {
+	labels := metricLabels("peer", peerUUID, "method", method)
+	m.metrics.observe("longpoll_server_peer_request_seconds", labels, time.Since(start).Seconds())
+	if err != nil {
+		m.metrics.add("longpoll_server_peer_errors_total", labels, 1)
+	}
+}
+
+// Counts a message that was delivered
+func (m *Manager) countSent(op string) {
+	m.metrics.add("longpoll_messages_sent_total", metricLabels("op", op), 1)
+}
+
+func writeHeader(sb *strings.Builder, name string, kind string, help string) {
+	sb.WriteString("# HELP " + name + " " + help + "\n")
+	sb.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+func writeSample(sb *strings.Builder, name string, labels string, value float64) {
+	sb.WriteString(name + labels + " " + formatFloat(value) + "\n")
+}
+
+// Formats label pairs eg: metricLabels("peer", "a") -> {peer="a"}
+func metricLabels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+"=\""+escapeLabel(pairs[i+1])+"\"")
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func joinLabels(a string, b string) string {
+	if a == "" {
+		return b
+	}
+	return a[:len(a)-1] + "," + b[1:]
+}
+
+func escapeLabel(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,94 @@
+package longpoll
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	m, err := NewManager(WithMetricsPath("/metrics"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	handler := m.Handler()
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("uuid", "client")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// Create the client peer, send it a message and poll it
+	if code := get(m.API_Path).Code; code != 201 {
+		t.Fatalf("first poll: got %d, want 201", code)
+	}
+	err = m.Send("client", "hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := get(m.API_Path).Code; code != 200 {
+		t.Fatalf("second poll: got %d, want 200", code)
+	}
+
+	// Scrape the metrics
+	w := get("/metrics")
+	if w.Code != 200 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	samples := make(map[string]float64)
+	types := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			types[fields[2]] = fields[3]
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("invalid sample %q", line)
+		}
+		samples[line[:i]] = value
+	}
+
+	wantTypes := map[string]string{
+		"longpoll_peers":                    "gauge",
+		"longpoll_peer_queue_depth":         "gauge",
+		"longpoll_http_responses_total":     "counter",
+		"longpoll_messages_sent_total":      "counter",
+		"longpoll_poll_wait_seconds":        "histogram",
+		"longpoll_server_peer_errors_total": "counter",
+	}
+	for name, kind := range wantTypes {
+		if types[name] != kind {
+			t.Errorf("%s: got type %q, want %q", name, types[name], kind)
+		}
+	}
+
+	// The scrape is counted after it is served, so only the two polls are in it
+	wantSamples := map[string]float64{
+		`longpoll_peers{kind="client",online="true"}`:                   1,
+		`longpoll_peers{kind="server",online="true"}`:                   0,
+		`longpoll_peer_queue_depth{peer="client"}`:                      0,
+		`longpoll_http_responses_total{method="GET",code="201"}`:        1,
+		`longpoll_http_responses_total{method="GET",code="200"}`:        1,
+		`longpoll_messages_sent_total{op="send"}`:                       1,
+		`longpoll_poll_wait_seconds_count{result="message"}`:            1,
+		`longpoll_poll_wait_seconds_bucket{result="message",le="+Inf"}`: 1,
+	}
+	for sample, want := range wantSamples {
+		got, ok := samples[sample]
+		if !ok {
+			t.Errorf("%s missing", sample)
+		} else if got != want {
+			t.Errorf("%s: got %v, want %v", sample, got, want)
+		}
+	}
+}
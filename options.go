@@ -0,0 +1,404 @@
+package longpoll
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Option Configures a Manager created by NewManager
+type Option func(m *Manager) error
+
+// NewManager Creates a new LongPoll Manager from the default settings and the given options.
+// The resulting settings are validated before the manager is returned
+func NewManager(opts ...Option) (*Manager, error) {
+	m := NewDefaultManager()
+	for _, opt := range opts {
+		err := opt(m)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Dummy checks
+	err := m.validate()
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WithUUID Sets the UUID the manager identifies itself with
+func WithUUID(uuid string) Option {
+	return func(m *Manager) error {
+		if uuid == "" {
+			return errors.New("WithUUID: uuid is required")
+		}
+		m.UUID = uuid
+		return nil
+	}
+}
+
+// WithPort Sets the port the API listens on
+func WithPort(port int) Option {
+	return func(m *Manager) error {
+		if port < 1 || port > 65535 {
+			return errors.New("WithPort: port must be between 1 and 65535, got " + strconv.Itoa(port))
+		}
+		m.API_Port = port
+		return nil
+	}
+}
+
+// WithPath Sets the path the API listens on eg: /poll
+func WithPath(path string) Option {
+	return func(m *Manager) error {
+		if !strings.HasPrefix(path, "/") {
+			return errors.New("WithPath: path must start with /")
+		}
+		m.API_Path = path
+		return nil
+	}
+}
+
+// WithPollLength Sets the time before a poll should be refreshed
+func WithPollLength(d time.Duration) Option {
+	return func(m *Manager) error {
+		m.PollLength = d
+		return nil
+	}
+}
+
+// WithPollLengthBounds Sets the shortest and longest poll durations a client may request (0 is unbounded)
+func WithPollLengthBounds(min time.Duration, max time.Duration) Option {
+	return func(m *Manager) error {
+		m.MinPollLength = min
+		m.MaxPollLength = max
+		return nil
+	}
+}
+
+// WithPeerExpiry Sets the time before a client peer is considered expired
+func WithPeerExpiry(d time.Duration) Option {
+	return func(m *Manager) error {
+		m.PeerExpiry = d
+		return nil
+	}
+}
+
+// WithDeadline Sets the time before sends and requests to server peers time out
+func WithDeadline(d time.Duration) Option {
+	return func(m *Manager) error {
+		m.Deadline = d
+		return nil
+	}
+}
+
+// WithOutboundBufferSize Sets the size of each client peer's outbound message buffer
+func WithOutboundBufferSize(size int) Option {
+	return func(m *Manager) error {
+		if size < 0 {
+			return errors.New("WithOutboundBufferSize: size must not be negative")
+		}
+		m.OutboundBufferSize = size
+		return nil
+	}
+}
+
+// WithCircuitBreaker Opens a server peer's circuit breaker after threshold consecutive failed requests, failing
+// sends fast for timeout before letting a request through (threshold 0 disables)
+func WithCircuitBreaker(threshold int, timeout time.Duration) Option {
+	return func(m *Manager) error {
+		if threshold < 0 {
+			return errors.New("WithCircuitBreaker: threshold must not be negative")
+		}
+		m.CircuitBreakerThreshold = threshold
+		m.CircuitBreakerTimeout = timeout
+		return nil
+	}
+}
+
+// WithMiddleware Sets the middleware to run before each API request
+func WithMiddleware(middleware gin.HandlerFunc) Option {
+	return func(m *Manager) error {
+		m.API_Middleware = middleware
+		return nil
+	}
+}
+
+// WithTransport Sets the transport used for requests to server peers
+func WithTransport(transport http.RoundTripper) Option {
+	return func(m *Manager) error {
+		m.Transport = transport
+		return nil
+	}
+}
+
+// WithTransportConfig Sets the connection pooling, proxy and dial settings for requests to server peers
+func WithTransportConfig(config TransportConfig) Option {
+	return func(m *Manager) error {
+		err := config.Validate()
+		if err != nil {
+			return errors.New("WithTransportConfig: " + err.Error())
+		}
+		m.TransportConfig = &config
+		return nil
+	}
+}
+
+// WithProxy Sends requests to server peers through an http, https or socks5 proxy eg: socks5://proxy:1080
+func WithProxy(proxyURL string) Option {
+	return func(m *Manager) error {
+		config := m.TransportConfig.merge(&TransportConfig{ProxyURL: proxyURL})
+		err := config.Validate()
+		if err != nil {
+			return errors.New("WithProxy: " + err.Error())
+		}
+		m.TransportConfig = config
+		return nil
+	}
+}
+
+// WithAuthenticator Sets the authenticator that verifies the identity of peers
+func WithAuthenticator(authenticator Authenticator) Option {
+	return func(m *Manager) error {
+		m.Authenticator = authenticator
+		return nil
+	}
+}
+
+// WithPeerLimits Sets the maximum number of peers in total and per IP address (0 is unlimited)
+func WithPeerLimits(maxPeers int, maxPeersPerIP int) Option {
+	return func(m *Manager) error {
+		if maxPeers < 0 || maxPeersPerIP < 0 {
+			return errors.New("WithPeerLimits: limits must not be negative")
+		}
+		m.MaxPeers = maxPeers
+		m.MaxPeersPerIP = maxPeersPerIP
+		return nil
+	}
+}
+
+// WithAllowedIPs Sets the IPs or CIDRs allowed to connect
+func WithAllowedIPs(ips ...string) Option {
+	return func(m *Manager) error {
+		_, err := parseNetworks(ips)
+		if err != nil {
+			return errors.New("WithAllowedIPs: " + err.Error())
+		}
+		m.AllowedIPs = ips
+		return nil
+	}
+}
+
+// WithDeniedIPs Sets the IPs or CIDRs denied from connecting
+func WithDeniedIPs(ips ...string) Option {
+	return func(m *Manager) error {
+		_, err := parseNetworks(ips)
+		if err != nil {
+			return errors.New("WithDeniedIPs: " + err.Error())
+		}
+		m.DeniedIPs = ips
+		return nil
+	}
+}
+
+// WithRateLimits Sets the token bucket rate limits for the API
+func WithRateLimits(limits RateLimits) Option {
+	return func(m *Manager) error {
+		m.RateLimits = limits
+		return nil
+	}
+}
+
+// WithInboundLimits Sets the size and format limits for inbound requests and messages
+func WithInboundLimits(limits InboundLimits) Option {
+	return func(m *Manager) error {
+		m.InboundLimits = limits
+		return nil
+	}
+}
+
+// WithTLSConfig Serves the API over HTTPS with config
+func WithTLSConfig(config *tls.Config) Option {
+	return func(m *Manager) error {
+		m.TLSConfig = config
+		return nil
+	}
+}
+
+// WithTLSFiles Serves the API over HTTPS with a PEM certificate and key that are reloaded when they change.
+// clientCAFile enables mutual TLS (empty disables)
+func WithTLSFiles(certFile string, keyFile string, clientCAFile string) Option {
+	return func(m *Manager) error {
+		if certFile == "" || keyFile == "" {
+			return errors.New("WithTLSFiles: certFile and keyFile are required")
+		}
+		m.TLSCertFile = certFile
+		m.TLSKeyFile = keyFile
+		m.TLSClientCAFile = clientCAFile
+		return nil
+	}
+}
+
+// WithKeyStore Sets the keys used to sign, verify, encrypt and decrypt messages
+func WithKeyStore(keyStore KeyStore) Option {
+	return func(m *Manager) error {
+		m.KeyStore = keyStore
+		return nil
+	}
+}
+
+// WithSigning Signs outgoing messages and optionally rejects incoming messages without a valid signature
+func WithSigning(require bool) Option {
+	return func(m *Manager) error {
+		m.SignMessages = true
+		m.RequireSignatures = require
+		return nil
+	}
+}
+
+// WithEncryption Encrypts outgoing messages and optionally rejects incoming messages that are not encrypted
+func WithEncryption(require bool) Option {
+	return func(m *Manager) error {
+		m.EncryptMessages = true
+		m.RequireEncryption = require
+		return nil
+	}
+}
+
+// WithTopicAuthorizer Sets who may subscribe and publish to topics
+func WithTopicAuthorizer(authorizer TopicAuthorizer) Option {
+	return func(m *Manager) error {
+		m.TopicAuthorizer = authorizer
+		return nil
+	}
+}
+
+// WithMetricsPath Serves Prometheus metrics on this path of the API eg: /metrics
+func WithMetricsPath(path string) Option {
+	return func(m *Manager) error {
+		if !strings.HasPrefix(path, "/") {
+			return errors.New("WithMetricsPath: path must start with /")
+		}
+		m.MetricsPath = path
+		return nil
+	}
+}
+
+// WithTracer Sets the tracer used to create spans
+func WithTracer(tracer Tracer) Option {
+	return func(m *Manager) error {
+		m.Tracer = tracer
+		return nil
+	}
+}
+
+// WithHealthChecks Serves /healthz and /readyz on the API
+func WithHealthChecks() Option {
+	return func(m *Manager) error {
+		m.HealthChecks = true
+		return nil
+	}
+}
+
+// WithAdminPath Serves the admin API under this path of the API eg: /admin
+func WithAdminPath(path string) Option {
+	return func(m *Manager) error {
+		if !strings.HasPrefix(path, "/") {
+			return errors.New("WithAdminPath: path must start with /")
+		}
+		m.AdminPath = path
+		return nil
+	}
+}
+
+// WithAdminPort Serves the admin API on its own port
+func WithAdminPort(port int) Option {
+	return func(m *Manager) error {
+		if port < 1 || port > 65535 {
+			return errors.New("WithAdminPort: port must be between 1 and 65535, got " + strconv.Itoa(port))
+		}
+		m.AdminPort = port
+		return nil
+	}
+}
+
+// WithAdminMiddleware Sets the middleware to run before each admin request eg: authentication
+func WithAdminMiddleware(middleware gin.HandlerFunc) Option {
+	return func(m *Manager) error {
+		m.AdminMiddleware = middleware
+		return nil
+	}
+}
+
+// WithAdmissionHook Sets the function to call before creating a new client peer
+func WithAdmissionHook(hook func(r *http.Request, peerUUID string) error) Option {
+	return func(m *Manager) error {
+		m.AdmissionHook = hook
+		return nil
+	}
+}
+
+// WithPeerSettingsHook Sets the function to call when creating a new client peer to choose its settings
+func WithPeerSettingsHook(hook func(r *http.Request, peerUUID string) PeerSettings) Option {
+	return func(m *Manager) error {
+		m.PeerSettingsHook = hook
+		return nil
+	}
+}
+
+// WithLogger Sets the logger for lifecycle events and errors
+func WithLogger(logger *slog.Logger) Option {
+	return func(m *Manager) error {
+		m.Logger = logger
+		return nil
+	}
+}
+
+// OnUp Sets the function to call when a peer comes online
+func OnUp(callback func(peerUUID string)) Option {
+	return func(m *Manager) error {
+		m.UpCallback = callback
+		return nil
+	}
+}
+
+// OnDown Sets the function to call when a peer goes offline
+func OnDown(callback func(peerUUID string)) Option {
+	return func(m *Manager) error {
+		m.DownCallback = callback
+		return nil
+	}
+}
+
+// OnReceive Sets the function to call when receiving a message
+func OnReceive(callback func(peerUUID string, msg Message)) Option {
+	return func(m *Manager) error {
+		m.ReceiveCallback = callback
+		return nil
+	}
+}
+
+// OnReceiveContext Sets the function to call when receiving a message, ctx carries its trace context
+func OnReceiveContext(callback func(ctx context.Context, peerUUID string, msg Message)) Option {
+	return func(m *Manager) error {
+		m.ReceiveContextCallback = callback
+		return nil
+	}
+}
+
+// OnReject Sets the function to call when a request is rejected
+func OnReject(callback func(peerUUID string, ipAddr string, err error)) Option {
+	return func(m *Manager) error {
+		m.RejectCallback = callback
+		return nil
+	}
+}
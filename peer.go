@@ -2,157 +2,299 @@ package longpoll
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"io"
-	"log"
 	"net/http"
-	"net/http/cookiejar"
 	"time"
 )
 
 // Poll the peer via GET request
-func (p *Peer) pollGET(deadline time.Duration, managerUUID string, jar *cookiejar.Jar) error {
-	// Create a new request
-	req, err := http.NewRequest("GET", p.ServerURL, nil)
-	if err != nil {
-		p.markOffline()
-		return err
-	}
-
-	// Set headers
-	req.Header.Set("uuid", managerUUID)
+func (p *Peer) pollGET(m *Manager) (err error) {
+	// Record the request duration and outcome
+	start := time.Now()
+	status := 0
+	defer func() {
+		m.observeServerRequest(p.UUID, "GET", start, err)
+		if err != nil {
+			m.emit(Event{Type: EventPollError, PeerUUID: p.UUID, Op: "poll", StatusCode: status, Err: err})
+		}
+	}()
 
-	// Set custom headers
-	for k, v := range p.Headers {
-		req.Header.Set(k, v)
-	}
+	// Send request, allowing for the negotiated poll duration and failing over between the server's URLs
+	p.retryAfter = 0
+	resp, err := p.do(context.Background(), m, p.pollTimeout(m), func(ctx context.Context, url string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
 
-	// Create client
-	client := &http.Client{
-		Timeout: deadline,
-		Jar:     jar,
-	}
+		// Set headers
+		req.Header.Set("uuid", m.UUID)
+		if token := p.getSessionToken(); token != "" {
+			req.Header.Set(SessionHeader, token)
+		}
 
-	// Send request
-	resp, err := client.Do(req)
+		// Set custom headers
+		for k, v := range p.headers() {
+			req.Header.Set(k, v)
+		}
+		p.requestPollDuration(req)
+		return req, nil
+	})
 	if err != nil {
-		p.markOffline()
+		p.markOffline(m, ReasonNetworkError, 0, err)
 		return err
 	}
+	defer resp.Body.Close()
+	status = resp.StatusCode
+	p.storePollDuration(resp)
+	p.retryAfter = parseRetryAfter(resp)
 
-	// Check remote manager UUID
-	remoteManagerUUID := resp.Header.Get("uuid")
-	if remoteManagerUUID != p.remoteManagerUUID {
-		log.Println("Poll Peer UUID changed from", stringPlaceHolder(p.remoteManagerUUID), "to", stringPlaceHolder(remoteManagerUUID))
-		p.remoteManagerUUID = remoteManagerUUID
-	}
+	// Check remote manager UUID and store the session token if one was issued
+	p.storeResponseIdentity(m, resp)
 
 	// Check response code
 	switch resp.StatusCode {
 	case 200:
 		// Read the response body
-		body, err := io.ReadAll(resp.Body)
+		body, err := readLimited(resp.Body, m.InboundLimits.MaxBodySize)
 		if err != nil {
 			return err
 		}
 
 		// Parse the message
-		var msg Message
-		err = json.Unmarshal(body, &msg)
+		msg, err := decodeMessage(body, m.InboundLimits)
 		if err != nil {
 			return err
 		}
 
-		// Call the receive callback
-		if p.receiveCallback != nil {
-			cb := *p.receiveCallback
-			go cb(p.UUID, msg)
+		// Verify and decrypt the message
+		msg, err = m.openMessage(p.UUID, msg)
+		if err != nil {
+			m.notifyReject(p.UUID, "", err)
+			p.markOnline(m)
+			return nil
 		}
-		p.markOnline()
+
+		// Call the receive callbacks
+		m.receive(p.UUID, msg)
+		p.markOnline(m)
 		return nil
 	case 201:
 		// Peer created on server
-		p.markOnline()
+		p.markOnline(m)
 		return nil
 	case 204:
 		// Poll finished without message
-		p.markOnline()
+		p.markOnline(m)
 		return nil
+	case 307, 308:
+		// Server is draining, poll the server it redirected to
+		return p.followRedirect(m, resp)
+	case 401:
+		// Session rejected, request a new one on the next poll
+		p.setSessionToken("")
+		err = errors.New("poll failed: " + resp.Status)
+		p.markOffline(m, ReasonBadStatus, resp.StatusCode, err)
+		return err
 	default:
 		// Error
-		p.markOffline()
-		return errors.New("poll failed: " + resp.Status)
+		err = errors.New("poll failed: " + resp.Status)
+		p.markOffline(m, ReasonBadStatus, resp.StatusCode, err)
+		return err
 	}
 }
 
 // Poll the peer via POST request
-func (p *Peer) pollPOST(msg Message, managerUUID string, deadline time.Duration, jar *cookiejar.Jar) error {
+func (p *Peer) pollPOST(ctx context.Context, m *Manager, msg Message) (err error) {
+	// Record the request duration and outcome
+	start := time.Now()
+	status := 0
+	_, span := m.startSpan(ctx, "longpoll.post", map[string]string{"peer": p.UUID, "url": p.serverURL()})
+	defer func() {
+		m.observeServerRequest(p.UUID, "POST", start, err)
+		span.End(err)
+		if err != nil {
+			m.emit(Event{Type: EventPollError, PeerUUID: p.UUID, Op: "post", MessageID: msg.MessageID, StatusCode: status, Err: err})
+		}
+	}()
+
 	// Marshal the message
 	msgBytes, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
 
-	// Create the request
-	req, err := http.NewRequest("POST", p.ServerURL, bytes.NewReader(msgBytes))
-	if err != nil {
-		return err
-	}
+	// Send the request, failing over between the server's URLs and following a drain redirect once
+	var resp *http.Response
+	for redirected := false; ; redirected = true {
+		resp, err = p.do(ctx, m, p.deadline(m), func(ctx context.Context, url string) (*http.Request, error) {
+			req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(msgBytes))
+			if err != nil {
+				return nil, err
+			}
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("uuid", managerUUID)
-
-	// Set custom headers
-	for k, v := range p.Headers {
-		req.Header.Set(k, v)
-	}
+			// Set headers
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("uuid", m.UUID)
+			if token := p.getSessionToken(); token != "" {
+				req.Header.Set(SessionHeader, token)
+			}
 
-	// Create the client
-	client := &http.Client{
-		Timeout: deadline,
-		Jar:     jar,
-	}
+			// Set custom headers
+			for k, v := range p.headers() {
+				req.Header.Set(k, v)
+			}
+			return req, nil
+		})
+		if err != nil {
+			return err
+		}
+		if redirected || !isRedirect(resp.StatusCode) {
+			break
+		}
 
-	// Send request
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+		// Move to the server the draining server redirected to
+		resp.Body.Close()
+		err = p.followRedirect(m, resp)
+		if err != nil {
+			return err
+		}
 	}
+	defer resp.Body.Close()
+	status = resp.StatusCode
 
-	// Check remote manager UUID
-	remoteManagerUUID := resp.Header.Get("uuid")
-	if remoteManagerUUID != p.remoteManagerUUID {
-		log.Println("Poll Peer UUID changed from", stringPlaceHolder(p.remoteManagerUUID), "to", stringPlaceHolder(remoteManagerUUID))
-		p.remoteManagerUUID = remoteManagerUUID
-	}
+	// Check remote manager UUID and store the session token if one was issued
+	p.storeResponseIdentity(m, resp)
 
 	// Check response code
 	switch resp.StatusCode {
 	case 200:
 		return nil
+	case 401:
+		// Session rejected, request a new one on the next poll
+		p.setSessionToken("")
+		return errors.New(resp.Status)
 	default:
 		return errors.New(resp.Status)
 	}
 }
 
-func (p *Peer) markOnline() {
-	if !p.Online {
-		p.Online = true
-		if p.upCallback != nil {
-			cb := *p.upCallback
-			go cb(p.UUID)
+// Emits an event if the response came from a different remote manager, and stores the session token if one was issued
+func (p *Peer) storeResponseIdentity(m *Manager, resp *http.Response) {
+	remoteManagerUUID := resp.Header.Get("uuid")
+	p.stateMU.Lock()
+	previous := p.remoteManagerUUID
+	p.remoteManagerUUID = remoteManagerUUID
+	if token := resp.Header.Get(SessionHeader); token != "" {
+		p.sessionToken = token
+	}
+	p.stateMU.Unlock()
+
+	if remoteManagerUUID != previous {
+		m.emit(Event{
+			Type:                      EventRemoteManagerChanged,
+			PeerUUID:                  p.UUID,
+			RemoteManagerUUID:         remoteManagerUUID,
+			PreviousRemoteManagerUUID: previous,
+		})
+	}
+}
+
+// Returns the headers applied to requests to a server peer. The map is replaced, never changed, so callers may
+// read it after the lock is released
+func (p *Peer) headers() map[string]string {
+	p.settingsMU.RLock()
+	defer p.settingsMU.RUnlock()
+	return p.Headers
+}
+
+func (p *Peer) setHeaders(headers map[string]string) {
+	p.settingsMU.Lock()
+	p.Headers = headers
+	p.settingsMU.Unlock()
+}
+
+// Returns the attributes appended to every outgoing message. The map is replaced, never changed
+func (p *Peer) stickyAttributes() map[string]string {
+	p.settingsMU.RLock()
+	defer p.settingsMU.RUnlock()
+	return p.StickyAttrbitues
+}
+
+func (p *Peer) setStickyAttributes(attributes map[string]string) {
+	p.settingsMU.Lock()
+	p.StickyAttrbitues = attributes
+	p.settingsMU.Unlock()
+}
+
+func (p *Peer) getSessionToken() string {
+	p.stateMU.RLock()
+	defer p.stateMU.RUnlock()
+	return p.sessionToken
+}
+
+func (p *Peer) setSessionToken(token string) {
+	p.stateMU.Lock()
+	p.sessionToken = token
+	p.stateMU.Unlock()
+}
+
+func (p *Peer) getRemoteManagerUUID() string {
+	p.stateMU.RLock()
+	defer p.stateMU.RUnlock()
+	return p.remoteManagerUUID
+}
+
+func (p *Peer) getIPAddr() string {
+	p.stateMU.RLock()
+	defer p.stateMU.RUnlock()
+	return p.ipAddr
+}
+
+func (p *Peer) setIPAddr(ipAddr string) {
+	p.stateMU.Lock()
+	p.ipAddr = ipAddr
+	p.stateMU.Unlock()
+}
+
+func (p *Peer) getLastConsumed() time.Time {
+	p.stateMU.RLock()
+	defer p.stateMU.RUnlock()
+	return p.LastConsumed
+}
+
+// Records that the client peer consumed a message or finished a poll
+func (p *Peer) consumed() {
+	p.stateMU.Lock()
+	p.LastConsumed = time.Now()
+	p.stateMU.Unlock()
+}
+
+// Marks a server peer online. Online is read under peersMU, so it is written under it too
+func (p *Peer) markOnline(m *Manager) {
+	m.peersMU.Lock()
+	changed := !p.Online
+	p.Online = true
+	m.peersMU.Unlock()
+	if changed {
+		m.emit(Event{Type: EventPeerOnline, PeerUUID: p.UUID})
+		if m.UpCallback != nil {
+			go m.UpCallback(p.UUID)
 		}
 	}
 }
 
-func (p *Peer) markOffline() {
-	if p.Online {
-		p.Online = false
-		if p.downCallback != nil {
-			cb := *p.downCallback
-			go cb(p.UUID)
+func (p *Peer) markOffline(m *Manager, reason string, status int, err error) {
+	m.peersMU.Lock()
+	changed := p.Online
+	p.Online = false
+	m.peersMU.Unlock()
+	if changed {
+		m.emit(Event{Type: EventPeerOffline, PeerUUID: p.UUID, Reason: reason, StatusCode: status, Err: err})
+		if m.DownCallback != nil {
+			go m.DownCallback(p.UUID)
 		}
 	}
 }
@@ -0,0 +1,176 @@
+package longpoll
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// PeerSettings Overrides the manager settings for a single peer. Zero values use the manager setting
+type PeerSettings struct {
+	PollLength         time.Duration // Time a poll from this client peer is held, or the retry delay for a server peer
+	PeerExpiry         time.Duration // Time before this client peer is considered expired
+	Deadline           time.Duration // Time before sends to this peer and requests to this server peer time out
+	OutboundBufferSize int           // Size of this client peer's outbound message buffer
+}
+
+// Validate Checks the overrides are zero or valid
+func (s PeerSettings) Validate() error {
+	if s.PollLength != 0 && s.PollLength < time.Second {
+		return errors.New("PollLength must be at least 1 second")
+	}
+	if s.PeerExpiry != 0 && s.PeerExpiry < time.Second {
+		return errors.New("PeerExpiry must be at least 1 second")
+	}
+	if s.Deadline != 0 && s.Deadline < time.Second {
+		return errors.New("deadline must be at least 1 second")
+	}
+	if s.OutboundBufferSize < 0 {
+		return errors.New("OutboundBufferSize must not be negative")
+	}
+	return nil
+}
+
+// MarshalJSON Encodes durations as strings eg: {"poll_length": "30s"}
+func (s PeerSettings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(peerSettingsJSON{
+		PollLength:         formatSetting(s.PollLength),
+		PeerExpiry:         formatSetting(s.PeerExpiry),
+		Deadline:           formatSetting(s.Deadline),
+		OutboundBufferSize: s.OutboundBufferSize,
+	})
+}
+
+// UnmarshalJSON Decodes durations from strings eg: "30s" or numbers of seconds
+func (s *PeerSettings) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		PollLength         interface{} `json:"poll_length"`
+		PeerExpiry         interface{} `json:"peer_expiry"`
+		Deadline           interface{} `json:"deadline"`
+		OutboundBufferSize int         `json:"outbound_buffer_size"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	var settings PeerSettings
+	for _, field := range []struct {
+		key   string
+		value interface{}
+		dst   *time.Duration
+	}{
+		{"poll_length", raw.PollLength, &settings.PollLength},
+		{"peer_expiry", raw.PeerExpiry, &settings.PeerExpiry},
+		{"deadline", raw.Deadline, &settings.Deadline},
+	} {
+		if field.value == nil {
+			continue
+		}
+		*field.dst, err = configDuration(field.value)
+		if err != nil {
+			return errors.New(field.key + ": " + err.Error())
+		}
+	}
+	settings.OutboundBufferSize = raw.OutboundBufferSize
+	*s = settings
+	return nil
+}
+
+type peerSettingsJSON struct {
+	PollLength         string `json:"poll_length,omitempty"`
+	PeerExpiry         string `json:"peer_expiry,omitempty"`
+	Deadline           string `json:"deadline,omitempty"`
+	OutboundBufferSize int    `json:"outbound_buffer_size,omitempty"`
+}
+
+func formatSetting(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// GetPeerSettings Gets the overrides for a peer
+func (m *Manager) GetPeerSettings(uuid string) (PeerSettings, error) {
+	m.peersMU.RLock()
+	peer, _ := m.peers[uuid]
+	m.peersMU.RUnlock()
+	if peer == nil {
+		return PeerSettings{}, errors.New("peer not found")
+	}
+	return peer.getSettings(), nil
+}
+
+// SetPeerSettings Replaces the overrides for a peer. Durations apply from the next poll or send;
+// a new OutboundBufferSize applies when a client peer is next created eg: after KickPeer
+func (m *Manager) SetPeerSettings(uuid string, settings PeerSettings) error {
+	err := settings.Validate()
+	if err != nil {
+		return err
+	}
+
+	m.peersMU.RLock()
+	peer, _ := m.peers[uuid]
+	m.peersMU.RUnlock()
+	if peer == nil {
+		return errors.New("peer not found")
+	}
+
+	peer.settingsMU.Lock()
+	peer.settings = settings
+	peer.settingsMU.Unlock()
+	return nil
+}
+
+// Runs the peer settings hook for a new client peer
+func (m *Manager) newPeerSettings(r *http.Request, uuid string) (PeerSettings, error) {
+	if m.PeerSettingsHook == nil {
+		return PeerSettings{}, nil
+	}
+	settings := m.PeerSettingsHook(r, uuid)
+	err := settings.Validate()
+	if err != nil {
+		return PeerSettings{}, &RejectError{Status: 500, Err: errors.New("invalid peer settings: " + err.Error())}
+	}
+	return settings, nil
+}
+
+func (p *Peer) getSettings() PeerSettings {
+	p.settingsMU.RLock()
+	defer p.settingsMU.RUnlock()
+	return p.settings
+}
+
+// Returns the peer's PollLength override or the manager setting
+func (p *Peer) pollLength(m *Manager) time.Duration {
+	if d := p.getSettings().PollLength; d != 0 {
+		return d
+	}
+	return m.pollLength()
+}
+
+// Returns the peer's PeerExpiry override or the manager setting
+func (p *Peer) peerExpiry(m *Manager) time.Duration {
+	if d := p.getSettings().PeerExpiry; d != 0 {
+		return d
+	}
+	return m.peerExpiry()
+}
+
+// Returns the peer's Deadline override or the manager setting
+func (p *Peer) deadline(m *Manager) time.Duration {
+	if d := p.getSettings().Deadline; d != 0 {
+		return d
+	}
+	return m.deadline()
+}
+
+// Returns the OutboundBufferSize override or the manager setting
+func (s PeerSettings) outboundBufferSize(m *Manager) int {
+	if s.OutboundBufferSize != 0 {
+		return s.OutboundBufferSize
+	}
+	return m.outboundBufferSize()
+}
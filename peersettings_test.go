@@ -0,0 +1,150 @@
+package longpoll
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Creates client peers by polling the manager's API
+func createClientPeers(t *testing.T, m *Manager, uuids ...string) {
+	t.Helper()
+	handler := m.Handler()
+	for _, uuid := range uuids {
+		r := httptest.NewRequest("GET", m.API_Path, nil)
+		r.Header.Set("uuid", uuid)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != 201 {
+			t.Fatalf("creating %s: got %d, want 201", uuid, w.Code)
+		}
+	}
+}
+
+// Returns a peer of the manager
+func getPeer(m *Manager, uuid string) *Peer {
+	m.peersMU.RLock()
+	defer m.peersMU.RUnlock()
+	return m.peers[uuid]
+}
+
+func TestPeerSettingsHookOverrides(t *testing.T) {
+	hooked := PeerSettings{PollLength: 2 * time.Second, PeerExpiry: 3 * time.Second, Deadline: time.Second, OutboundBufferSize: 1}
+	m, err := NewManager(
+		WithPollLength(30*time.Second),
+		WithPeerExpiry(time.Minute),
+		WithDeadline(10*time.Second),
+		WithOutboundBufferSize(50),
+		WithPeerSettingsHook(func(r *http.Request, peerUUID string) PeerSettings {
+			if peerUUID == "hooked" {
+				return hooked
+			}
+			return PeerSettings{}
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	createClientPeers(t, m, "hooked", "default")
+
+	tests := []struct {
+		uuid       string
+		pollLength time.Duration
+		expiry     time.Duration
+		deadline   time.Duration
+		buffer     int
+	}{
+		{"hooked", 2 * time.Second, 3 * time.Second, time.Second, 1},
+		{"default", 30 * time.Second, time.Minute, 10 * time.Second, 50},
+	}
+	for _, tt := range tests {
+		p := getPeer(m, tt.uuid)
+		if got := p.pollLength(m); got != tt.pollLength {
+			t.Errorf("%s: poll length %s, want %s", tt.uuid, got, tt.pollLength)
+		}
+		if got := p.peerExpiry(m); got != tt.expiry {
+			t.Errorf("%s: expiry %s, want %s", tt.uuid, got, tt.expiry)
+		}
+		if got := p.deadline(m); got != tt.deadline {
+			t.Errorf("%s: deadline %s, want %s", tt.uuid, got, tt.deadline)
+		}
+		if got := cap(p.Ch); got != tt.buffer {
+			t.Errorf("%s: buffer %d, want %d", tt.uuid, got, tt.buffer)
+		}
+	}
+	settings, err := m.GetPeerSettings("hooked")
+	if err != nil || settings != hooked {
+		t.Fatalf("got settings %+v, %v", settings, err)
+	}
+
+	// A send to the full buffer times out after the hooked deadline, not the manager's
+	err = m.Send("hooked", "first", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	err = m.Send("hooked", "second", nil)
+	if err == nil {
+		t.Fatal("send to a full buffer succeeded")
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 5*time.Second {
+		t.Fatalf("send timed out after %s, want the 1s peer deadline", elapsed)
+	}
+}
+
+func TestPeerSettingsHookInvalid(t *testing.T) {
+	m, err := NewManager(WithPeerSettingsHook(func(r *http.Request, peerUUID string) PeerSettings {
+		return PeerSettings{PollLength: time.Millisecond}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	r := httptest.NewRequest("GET", m.API_Path, nil)
+	r.Header.Set("uuid", "client")
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, r)
+	if w.Code != 500 || m.PeerExists("client") {
+		t.Fatalf("got %d, want 500 and no peer", w.Code)
+	}
+}
+
+func TestSetPeerSettingsOverrides(t *testing.T) {
+	m, err := NewManager(WithPeerExpiry(time.Minute), WithDeadline(10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	createClientPeers(t, m, "short", "default")
+
+	err = m.SetPeerSettings("short", PeerSettings{PeerExpiry: time.Second, Deadline: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := getPeer(m, "short").deadline(m); got != 2*time.Second {
+		t.Fatalf("deadline %s, want 2s", got)
+	}
+
+	// Invalid overrides and unknown peers are rejected
+	if err := m.SetPeerSettings("short", PeerSettings{Deadline: time.Millisecond}); err == nil {
+		t.Fatal("invalid settings accepted")
+	}
+	if err := m.SetPeerSettings("missing", PeerSettings{}); err == nil {
+		t.Fatal("settings for a missing peer accepted")
+	}
+
+	// Both peers were idle for 2 seconds, only the one with the shorter expiry is removed
+	for _, uuid := range []string{"short", "default"} {
+		p := getPeer(m, uuid)
+		p.stateMU.Lock()
+		p.LastConsumed = time.Now().Add(-2 * time.Second)
+		p.stateMU.Unlock()
+	}
+	m.garbageCollectPeers()
+	if m.PeerExists("short") || !m.PeerExists("default") {
+		t.Fatalf("short exists %v, default exists %v", m.PeerExists("short"), m.PeerExists("default"))
+	}
+}
@@ -0,0 +1,122 @@
+package longpoll
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PollDurationHeader Header a client sets to request a poll duration eg: "55s" or "55" (seconds). The server
+// replies with the effective duration in seconds. A "poll" query parameter is accepted in place of the header
+const PollDurationHeader = "poll-duration"
+
+// Grace period on top of the effective poll duration before a poll request times out
+const pollTimeoutGrace = 10 * time.Second
+
+// Chooses how long to hold a poll: the duration the client asked for clamped to MinPollLength and MaxPollLength,
+// or the peer's PollLength when it didn't ask. The effective duration is echoed in the response
+func (m *Manager) negotiatePollLength(c *gin.Context, peer *Peer) time.Duration {
+	wait := peer.pollLength(m)
+
+	requested := c.GetHeader(PollDurationHeader)
+	if requested == "" {
+		requested = c.Query("poll")
+	}
+	if requested != "" {
+		d, ok := parsePollDuration(requested)
+		if ok {
+			wait = m.clampPollLength(d)
+		}
+	}
+
+	c.Header(PollDurationHeader, formatPollDuration(wait))
+	return wait
+}
+
+// Clamps a requested poll duration to MinPollLength and MaxPollLength
+func (m *Manager) clampPollLength(d time.Duration) time.Duration {
+	m.settingsMU.RLock()
+	defer m.settingsMU.RUnlock()
+	if m.MinPollLength > 0 && d < m.MinPollLength {
+		d = m.MinPollLength
+	}
+	if m.MaxPollLength > 0 && d > m.MaxPollLength {
+		d = m.MaxPollLength
+	}
+	return d
+}
+
+// Sets the requested poll duration on a poll request to a server peer
+func (p *Peer) requestPollDuration(req *http.Request) {
+	if d := p.requestedPollDuration(); d > 0 {
+		req.Header.Set(PollDurationHeader, formatPollDuration(d))
+	}
+}
+
+// Returns the poll duration to request from a server peer, which Reload may change
+func (p *Peer) requestedPollDuration() time.Duration {
+	p.settingsMU.RLock()
+	defer p.settingsMU.RUnlock()
+	return p.pollDuration
+}
+
+func (p *Peer) setRequestedPollDuration(d time.Duration) {
+	p.settingsMU.Lock()
+	p.pollDuration = d
+	p.settingsMU.Unlock()
+}
+
+// Stores the effective poll duration the server peer replied with
+func (p *Peer) storePollDuration(resp *http.Response) {
+	d, ok := parsePollDuration(resp.Header.Get(PollDurationHeader))
+	if ok {
+		p.settingsMU.Lock()
+		p.effectivePollDuration = d
+		p.settingsMU.Unlock()
+	}
+}
+
+// Returns the timeout for a poll request to a server peer: the effective poll duration from the last
+// response plus a grace period, the requested duration if the server hasn't replied yet, or the Deadline
+func (p *Peer) pollTimeout(m *Manager) time.Duration {
+	p.settingsMU.RLock()
+	effective := p.effectivePollDuration
+	requested := p.pollDuration
+	p.settingsMU.RUnlock()
+
+	switch {
+	case effective > 0:
+		return effective + pollTimeoutGrace
+	case requested > 0:
+		return requested + pollTimeoutGrace
+	}
+	return p.deadline(m)
+}
+
+// Parses "55s" or "55" (seconds)
+func parsePollDuration(s string) (time.Duration, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		// Reject NaN, infinities and values that overflow a time.Duration
+		if !(seconds > 0 && seconds < math.MaxInt64/float64(time.Second)) {
+			return 0, false
+		}
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+	return d, true
+}
+
+func formatPollDuration(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
@@ -0,0 +1,66 @@
+package longpoll
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePollDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"55", 55 * time.Second, true},
+		{"1.5", 1500 * time.Millisecond, true},
+		{" 30s ", 30 * time.Second, true},
+		{"2m", 2 * time.Minute, true},
+		{"", 0, false},
+		{"0", 0, false},
+		{"0s", 0, false},
+		{"-5", 0, false},
+		{"-5s", 0, false},
+		{"abc", 0, false},
+		{"5 minutes", 0, false},
+		{"NaN", 0, false},
+		{"Inf", 0, false},
+		{"1e300", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := parsePollDuration(tt.value)
+			if got != tt.want || ok != tt.ok {
+				t.Fatalf("got %s %v, want %s %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestClampPollLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max time.Duration
+		d        time.Duration
+		want     time.Duration
+	}{
+		{"within bounds", 10 * time.Second, time.Minute, 30 * time.Second, 30 * time.Second},
+		{"below min", 10 * time.Second, time.Minute, time.Second, 10 * time.Second},
+		{"above max", 10 * time.Second, time.Minute, time.Hour, time.Minute},
+		{"at min", 10 * time.Second, time.Minute, 10 * time.Second, 10 * time.Second},
+		{"at max", 10 * time.Second, time.Minute, time.Minute, time.Minute},
+		{"no bounds", 0, 0, time.Hour, time.Hour},
+		{"min only", 10 * time.Second, 0, time.Hour, time.Hour},
+		{"max only", 0, time.Minute, time.Millisecond, time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewManager(WithPollLengthBounds(tt.min, tt.max))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.clampPollLength(tt.d); got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package longpoll
+
+// A client peer's queue is its Ch channel. queue holds a copy of the messages in Ch in the same order so they
+// can be read without taking them. Sends to Ch go through enqueue, receives are followed by dequeued and
+// draining goes through drainQueue, all under queueMU
+
+// Queues a message without blocking. If the queue is full it returns false and a channel that is closed once
+// a message leaves the queue
+func (p *Peer) enqueue(msg Message) (bool, <-chan struct{}) {
+	p.queueMU.Lock()
+	defer p.queueMU.Unlock()
+	select {
+	case p.Ch <- msg:
+		p.queue = append(p.queue, msg)
+		return true, nil
+	default:
+	}
+	if p.space == nil {
+		p.space = make(chan struct{})
+	}
+	return false, p.space
+}
+
+// Records that a message was received from Ch. Messages leave Ch in the order they were queued
+func (p *Peer) dequeued() {
+	p.queueMU.Lock()
+	defer p.queueMU.Unlock()
+	if len(p.queue) > 0 {
+		p.queue[0] = Message{}
+		p.queue = p.queue[1:]
+	}
+	p.signalSpace()
+}
+
+// Takes every message currently in the queue without blocking
+func (p *Peer) drainQueue() []Message {
+	p.queueMU.Lock()
+	defer p.queueMU.Unlock()
+	var messages []Message
+drain:
+	for {
+		select {
+		case msg := <-p.Ch:
+			messages = append(messages, msg)
+		default:
+			break drain
+		}
+	}
+
+	// Keep the copies of messages received but not yet recorded by dequeued
+	if len(messages) < len(p.queue) {
+		p.queue = p.queue[:len(p.queue)-len(messages)]
+	} else {
+		p.queue = nil
+	}
+	p.signalSpace()
+	return messages
+}
+
+// Returns the messages in the queue, oldest first, without taking them
+func (p *Peer) peekQueue() []Message {
+	p.queueMU.Lock()
+	defer p.queueMU.Unlock()
+
+	// Skip copies of messages received but not yet recorded by dequeued
+	n := min(len(p.Ch), len(p.queue))
+	messages := make([]Message, n)
+	copy(messages, p.queue[len(p.queue)-n:])
+	return messages
+}
+
+// Wakes senders waiting for space. Must be called with queueMU held
+func (p *Peer) signalSpace() {
+	if p.space != nil {
+		close(p.space)
+		p.space = nil
+	}
+}
@@ -0,0 +1,160 @@
+package longpoll
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited Wrapped by every RateLimitError
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// Rate A token bucket rate limit
+type Rate struct {
+	Limit float64 // Tokens added per second (0 is unlimited)
+	Burst int     // Maximum tokens in the bucket
+}
+
+// RateLimits Token bucket limits for the poll API, applied per peer UUID and per client IP
+type RateLimits struct {
+	PollsPerPeer    Rate // GET requests
+	PollsPerIP      Rate
+	MessagesPerPeer Rate // POST requests
+	MessagesPerIP   Rate
+	BytesPerPeer    Rate // POST request body bytes
+	BytesPerIP      Rate
+}
+
+// Validate Checks that every limit has a burst of at least 1
+func (r RateLimits) Validate() error {
+	rates := []struct {
+		name string
+		rate Rate
+	}{
+		{"PollsPerPeer", r.PollsPerPeer},
+		{"PollsPerIP", r.PollsPerIP},
+		{"MessagesPerPeer", r.MessagesPerPeer},
+		{"MessagesPerIP", r.MessagesPerIP},
+		{"BytesPerPeer", r.BytesPerPeer},
+		{"BytesPerIP", r.BytesPerIP},
+	}
+	for _, r := range rates {
+		if r.rate.Limit > 0 && r.rate.Burst < 1 {
+			// The bucket would never hold a token and every request would be rejected
+			return errors.New(r.name + " Burst must be at least 1")
+		}
+	}
+	return nil
+}
+
+// RateLimitError Returned to the reject callback when a request is rate limited
+type RateLimitError struct {
+	Limit      string        // Name of the limit eg: "PollsPerPeer"
+	Key        string        // Peer UUID or IP address the limit applies to
+	RetryAfter time.Duration // Time until the request would be allowed
+}
+
+func (e *RateLimitError) Error() string {
+	return "rate limit exceeded: " + e.Limit + " (" + e.Key + ")"
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+type rateLimiter struct {
+	buckets   map[string]*tokenBucket
+	bucketsMU sync.Mutex
+}
+
+type tokenBucket struct {
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Takes n tokens from the bucket for limit and key. Returns a RateLimitError if there are not enough
+func (l *rateLimiter) take(limit string, key string, rate Rate, n float64) error {
+	if rate.Limit <= 0 {
+		return nil
+	}
+
+	l.bucketsMU.Lock()
+	defer l.bucketsMU.Unlock()
+
+	// Get or create the bucket
+	now := time.Now()
+	id := limit + ":" + key
+	b, _ := l.buckets[id]
+	if b == nil || b.rate != rate {
+		b = &tokenBucket{
+			rate:   rate,
+			tokens: float64(rate.Burst),
+			last:   now,
+		}
+		l.buckets[id] = b
+	}
+
+	// Refill the bucket
+	b.tokens = math.Min(float64(rate.Burst), b.tokens+now.Sub(b.last).Seconds()*rate.Limit)
+	b.last = now
+
+	// Requests larger than the burst are allowed once the bucket is full, leaving it in debt
+	need := math.Min(n, float64(rate.Burst))
+	if b.tokens < need {
+		wait := time.Duration((need - b.tokens) / rate.Limit * float64(time.Second))
+		return &RateLimitError{
+			Limit:      limit,
+			Key:        key,
+			RetryAfter: wait,
+		}
+	}
+	b.tokens -= n
+	return nil
+}
+
+// Deletes buckets that have refilled completely
+func (l *rateLimiter) prune() {
+	l.bucketsMU.Lock()
+	defer l.bucketsMU.Unlock()
+	now := time.Now()
+	for id, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.rate.Limit >= float64(b.rate.Burst) {
+			delete(l.buckets, id)
+		}
+	}
+}
+
+// Checks the per-IP poll or message rate limit for a request. Runs before authentication
+func (m *Manager) checkIPRequestRate(method string, ipAddr string) error {
+	if method == "POST" {
+		return m.limiter.take("MessagesPerIP", ipAddr, m.RateLimits.MessagesPerIP, 1)
+	}
+	return m.limiter.take("PollsPerIP", ipAddr, m.RateLimits.PollsPerIP, 1)
+}
+
+// Checks the per-peer poll or message rate limit for a request. Runs after authentication so an
+// unauthenticated request can't use up another peer's bucket
+func (m *Manager) checkPeerRequestRate(method string, uuid string) error {
+	if method == "POST" {
+		return m.limiter.take("MessagesPerPeer", uuid, m.RateLimits.MessagesPerPeer, 1)
+	}
+	return m.limiter.take("PollsPerPeer", uuid, m.RateLimits.PollsPerPeer, 1)
+}
+
+// Checks the inbound byte rate limits for a request body
+func (m *Manager) checkByteRate(uuid string, ipAddr string, size int) error {
+	limits := m.RateLimits
+	err := m.limiter.take("BytesPerIP", ipAddr, limits.BytesPerIP, float64(size))
+	if err != nil {
+		return err
+	}
+	return m.limiter.take("BytesPerPeer", uuid, limits.BytesPerPeer, float64(size))
+}
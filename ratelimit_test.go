@@ -0,0 +1,66 @@
+package longpoll
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPeerRateLimitAfterAuthentication(t *testing.T) {
+	m, err := NewManager(
+		WithAuthenticator(&TokenAuthenticator{Tokens: map[string]string{"good": "victim"}}),
+		WithRateLimits(RateLimits{PollsPerPeer: Rate{Limit: 0.001, Burst: 1}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := m.Handler()
+	poll := func(token string) int {
+		r := httptest.NewRequest("GET", m.API_Path, nil)
+		r.Header.Set("uuid", "victim")
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Unauthenticated requests must not use up the victim's bucket
+	for i := 0; i < 3; i++ {
+		if code := poll("bad"); code != 401 {
+			t.Fatalf("bad token: got %d, want 401", code)
+		}
+	}
+	if code := poll("good"); code != 201 {
+		t.Fatalf("first authenticated poll: got %d, want 201", code)
+	}
+	if code := poll("good"); code != 429 {
+		t.Fatalf("second authenticated poll: got %d, want 429", code)
+	}
+}
+
+func TestRateLimitsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits RateLimits
+		ok     bool
+	}{
+		{"unlimited", RateLimits{}, true},
+		{"unlimited without burst", RateLimits{PollsPerPeer: Rate{Burst: 0}}, true},
+		{"limit with burst", RateLimits{PollsPerPeer: Rate{Limit: 1, Burst: 1}}, true},
+		{"limit without burst", RateLimits{MessagesPerIP: Rate{Limit: 1}}, false},
+		{"limit with negative burst", RateLimits{BytesPerPeer: Rate{Limit: 1, Burst: -1}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limits.Validate()
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v, want ok %v", err, tt.ok)
+			}
+
+			// The manager rejects the limits too
+			_, err = NewManager(WithRateLimits(tt.limits))
+			if (err == nil) != tt.ok {
+				t.Fatalf("NewManager: got %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
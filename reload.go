@@ -0,0 +1,333 @@
+package longpoll
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Reload Applies a config to a running manager without dropping peers or their queued messages.
+// Poll length, expiry, deadline, buffer size (for new peers), circuit breaker, peer limits, IP lists and the TLS certificate
+// change in place. Server peers from the previous config are added, updated or removed to match; peers added
+// with AddServerPeer are left alone. Settings that need a restart eg: port and path return a *ConfigError
+// and nothing is applied
+func (m *Manager) Reload(cfg *Config) error {
+	// Dummy checks
+	err := cfg.Validate()
+	if err != nil {
+		return err
+	}
+	err = m.checkReloadable(cfg)
+	if err != nil {
+		return err
+	}
+	allowed, err := parseNetworks(cfg.AllowedIPs)
+	if err != nil {
+		return &ConfigError{Key: "allowed_ips", Err: err}
+	}
+	denied, err := parseNetworks(cfg.DeniedIPs)
+	if err != nil {
+		return &ConfigError{Key: "denied_ips", Err: err}
+	}
+
+	// Rotate the certificate first so a broken one rejects the whole reload
+	if m.certs != nil && (cfg.TLSCertFile != m.TLSCertFile || cfg.TLSKeyFile != m.TLSKeyFile) {
+		err = m.certs.load(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return &ConfigError{Key: "tls_cert_file", Err: err}
+		}
+	}
+
+	// Apply the settings
+	var changed []string
+	m.settingsMU.Lock()
+	if m.PollLength != cfg.PollLength {
+		m.PollLength = cfg.PollLength
+		changed = append(changed, "poll_length")
+	}
+	if m.MinPollLength != cfg.MinPollLength {
+		m.MinPollLength = cfg.MinPollLength
+		changed = append(changed, "min_poll_length")
+	}
+	if m.MaxPollLength != cfg.MaxPollLength {
+		m.MaxPollLength = cfg.MaxPollLength
+		changed = append(changed, "max_poll_length")
+	}
+	if m.PeerExpiry != cfg.PeerExpiry {
+		m.PeerExpiry = cfg.PeerExpiry
+		changed = append(changed, "peer_expiry")
+	}
+	if m.Deadline != cfg.Deadline {
+		m.Deadline = cfg.Deadline
+		changed = append(changed, "deadline")
+	}
+	if m.OutboundBufferSize != cfg.OutboundBufferSize {
+		m.OutboundBufferSize = cfg.OutboundBufferSize
+		changed = append(changed, "outbound_buffer_size")
+	}
+	if m.CircuitBreakerThreshold != cfg.CircuitBreakerThreshold {
+		m.CircuitBreakerThreshold = cfg.CircuitBreakerThreshold
+		changed = append(changed, "circuit_breaker_threshold")
+	}
+	if m.CircuitBreakerTimeout != cfg.CircuitBreakerTimeout {
+		m.CircuitBreakerTimeout = cfg.CircuitBreakerTimeout
+		changed = append(changed, "circuit_breaker_timeout")
+	}
+	if m.MaxPeers != cfg.MaxPeers {
+		m.MaxPeers = cfg.MaxPeers
+		changed = append(changed, "max_peers")
+	}
+	if m.MaxPeersPerIP != cfg.MaxPeersPerIP {
+		m.MaxPeersPerIP = cfg.MaxPeersPerIP
+		changed = append(changed, "max_peers_per_ip")
+	}
+	if !reflect.DeepEqual(m.AllowedIPs, cfg.AllowedIPs) {
+		m.AllowedIPs = cfg.AllowedIPs
+		m.allowedNets = allowed
+		changed = append(changed, "allowed_ips")
+	}
+	if !reflect.DeepEqual(m.DeniedIPs, cfg.DeniedIPs) {
+		m.DeniedIPs = cfg.DeniedIPs
+		m.deniedNets = denied
+		changed = append(changed, "denied_ips")
+	}
+	if m.TLSCertFile != cfg.TLSCertFile || m.TLSKeyFile != cfg.TLSKeyFile {
+		m.TLSCertFile = cfg.TLSCertFile
+		m.TLSKeyFile = cfg.TLSKeyFile
+		changed = append(changed, "tls_cert_file")
+	}
+	previous := m.config
+	m.config = cfg
+	m.settingsMU.Unlock()
+
+	for _, key := range changed {
+		m.emit(Event{Type: EventConfigChanged, Reason: key})
+	}
+
+	// Reconcile server peers
+	return m.reloadServerPeers(previous, cfg)
+}
+
+// ReloadOnSignal Reloads the config file at path whenever the process receives SIGHUP (or the given signals).
+// Failed reloads are logged and emitted as error events, and leave the running config unchanged.
+// Call the returned function to stop watching
+func (m *Manager) ReloadOnSignal(path string, signals ...os.Signal) (stop func()) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGHUP}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-m.stop:
+				return
+			case <-ch:
+				cfg, err := LoadConfig(path)
+				if err == nil {
+					err = m.Reload(cfg)
+				}
+				if err != nil {
+					m.emit(Event{Type: EventError, Reason: "reload", Err: err})
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
+
+// Returns a *ConfigError for the first setting that can't change without a restart
+func (m *Manager) checkReloadable(cfg *Config) error {
+	switch {
+	case cfg.UUID != "" && cfg.UUID != m.UUID:
+		return restartRequired("uuid")
+	case cfg.Port != m.API_Port:
+		return restartRequired("port")
+	case cfg.Path != m.API_Path:
+		return restartRequired("path")
+	case cfg.MetricsPath != m.MetricsPath:
+		return restartRequired("metrics_path")
+	case cfg.AdminPath != m.AdminPath:
+		return restartRequired("admin_path")
+	case cfg.AdminPort != m.AdminPort:
+		return restartRequired("admin_port")
+	case cfg.HealthChecks != m.HealthChecks:
+		return restartRequired("health_checks")
+	case cfg.TLSClientCAFile != m.TLSClientCAFile:
+		return restartRequired("tls_client_ca_file")
+	case (cfg.TLSCertFile != "") != (m.TLSCertFile != ""):
+		return restartRequired("tls_cert_file")
+	case !reflect.DeepEqual(cfg.Transport, m.TransportConfig):
+		return restartRequired("transport")
+	}
+	return nil
+}
+
+func restartRequired(key string) error {
+	return &ConfigError{Key: key, Err: errors.New("cannot be changed without a restart")}
+}
+
+// Adds, updates and removes server peers to match the config. Only peers from the previous config are removed
+func (m *Manager) reloadServerPeers(previous *Config, cfg *Config) error {
+	wanted := make(map[string]bool, len(cfg.ServerPeers))
+	for _, entry := range cfg.ServerPeers {
+		wanted[entry.UUID] = true
+	}
+
+	// Remove peers that are no longer configured
+	if previous != nil {
+		for _, entry := range previous.ServerPeers {
+			if wanted[entry.UUID] {
+				continue
+			}
+			err := m.DeletePeer(entry.UUID)
+			if err == nil {
+				m.emit(Event{Type: EventConfigChanged, PeerUUID: entry.UUID, Reason: "server_peers.removed"})
+			}
+		}
+	}
+
+	// Add new peers and update existing ones in place
+	var firstErr error
+	for i, entry := range cfg.ServerPeers {
+		var err error
+		if m.PeerExists(entry.UUID) {
+			err = m.updateServerPeer(entry)
+		} else {
+			err = m.addServerPeerEntry(entry)
+			if err == nil {
+				m.emit(Event{Type: EventConfigChanged, PeerUUID: entry.UUID, Reason: "server_peers.added"})
+			}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = &ConfigError{Key: "server_peers[" + strconv.Itoa(i) + "]", Err: err}
+		}
+	}
+	return firstErr
+}
+
+// Updates a running server peer from a config entry, keeping its poll loop and session
+func (m *Manager) updateServerPeer(entry ServerPeerEntry) error {
+	m.peersMU.RLock()
+	peer, _ := m.peers[entry.UUID]
+	m.peersMU.RUnlock()
+	if peer == nil || !peer.IsServer {
+		return errors.New("peer " + entry.UUID + " exists and is not a server peer")
+	}
+
+	// Build the new client before changing anything, keeping the cookie jar
+	config := entry.config()
+	client := peer.httpClient()
+	tlsChanged := !reflect.DeepEqual(peer.tlsConfig, entry.TLS)
+	transportChanged := !reflect.DeepEqual(peer.transportConfig, entry.Transport)
+	if tlsChanged || transportChanged {
+		c, err := m.peerClient(config, client.Jar)
+		if err != nil {
+			return err
+		}
+		client = c
+	}
+
+	var changed []string
+	m.peersMU.Lock()
+	if urls := config.urls(); !reflect.DeepEqual(peer.endpoints.urls(), urls) || peer.endpoints.getStrategy() != config.Strategy {
+		peer.endpoints.set(urls, config.Strategy)
+		peer.setServerURL(urls[0])
+		changed = append(changed, "server_peers.url")
+	}
+	if !reflect.DeepEqual(peer.headers(), entry.Headers) {
+		peer.setHeaders(entry.Headers)
+		changed = append(changed, "server_peers.headers")
+	}
+	if !reflect.DeepEqual(peer.stickyAttributes(), entry.StickyAttributes) {
+		peer.setStickyAttributes(entry.StickyAttributes)
+		changed = append(changed, "server_peers.sticky_attributes")
+	}
+	peer.healthMU.Lock()
+	if peer.required != entry.Required {
+		peer.required = entry.Required
+		changed = append(changed, "server_peers.required")
+	}
+	peer.healthMU.Unlock()
+	if !reflect.DeepEqual(peer.getRedirectHosts(), entry.RedirectHosts) {
+		peer.setRedirectHosts(entry.RedirectHosts)
+		changed = append(changed, "server_peers.redirect_hosts")
+	}
+	if peer.requestedPollDuration() != entry.PollDuration {
+		peer.setRequestedPollDuration(entry.PollDuration)
+		changed = append(changed, "server_peers.poll_duration")
+	}
+	if tlsChanged || transportChanged {
+		peer.settingsMU.Lock()
+		peer.client = client
+		peer.settingsMU.Unlock()
+	}
+	if tlsChanged {
+		peer.tlsConfig = entry.TLS
+		changed = append(changed, "server_peers.tls")
+	}
+	if transportChanged {
+		peer.transportConfig = entry.Transport
+		changed = append(changed, "server_peers.transport")
+	}
+	topicsChanged := !reflect.DeepEqual(peer.Topics, entry.Topics) && !(len(peer.Topics) == 0 && len(entry.Topics) == 0)
+	m.peersMU.Unlock()
+
+	// Topics are checked against the TopicAuthorizer, which SetTopics calls without peersMU held
+	if topicsChanged {
+		err := m.SetTopics(entry.UUID, entry.Topics)
+		if err != nil {
+			return err
+		}
+		changed = append(changed, "server_peers.topics")
+	}
+
+	for _, key := range changed {
+		m.emit(Event{Type: EventConfigChanged, PeerUUID: entry.UUID, Reason: key})
+	}
+	return nil
+}
+
+// Returns PollLength, which Reload may change
+func (m *Manager) pollLength() time.Duration {
+	m.settingsMU.RLock()
+	defer m.settingsMU.RUnlock()
+	return m.PollLength
+}
+
+// Returns PeerExpiry, which Reload may change
+func (m *Manager) peerExpiry() time.Duration {
+	m.settingsMU.RLock()
+	defer m.settingsMU.RUnlock()
+	return m.PeerExpiry
+}
+
+// Returns Deadline, which Reload may change
+func (m *Manager) deadline() time.Duration {
+	m.settingsMU.RLock()
+	defer m.settingsMU.RUnlock()
+	return m.Deadline
+}
+
+// Returns OutboundBufferSize, which Reload may change
+func (m *Manager) outboundBufferSize() int {
+	m.settingsMU.RLock()
+	defer m.settingsMU.RUnlock()
+	return m.OutboundBufferSize
+}
@@ -0,0 +1,103 @@
+package longpoll
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReloadServerPeerTopics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.ServerPeers = []ServerPeerEntry{{UUID: "server", URL: server.URL, Topics: []string{"a"}}}
+	m, err := NewManagerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	// Reload while the topics change concurrently, run with -race
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			m.AddTopic("server", "t"+strconv.Itoa(i))
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		err := m.Reload(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	err = m.Reload(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topics, err := m.GetTopics("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 1 || topics[0] != "a" {
+		t.Fatalf("got topics %v, want [a]", topics)
+	}
+}
+
+func TestReloadServerPeerWhilePolling(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.WriteHeader(204)
+		}
+	}))
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.ServerPeers = []ServerPeerEntry{{UUID: "server", URL: server.URL}}
+	m, err := NewManagerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	// Send while headers, sticky attributes and the poll duration are reloaded, run with -race
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			m.Send("server", "hello", nil)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		next := DefaultConfig()
+		next.ServerPeers = []ServerPeerEntry{{
+			UUID:             "server",
+			URL:              server.URL,
+			Headers:          map[string]string{"Authorization": "Bearer " + strconv.Itoa(i)},
+			StickyAttributes: map[string]string{"reload": strconv.Itoa(i)},
+			PollDuration:     time.Duration(i+1) * time.Second,
+		}}
+		err := m.Reload(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	m.peersMU.RLock()
+	peer := m.peers["server"]
+	m.peersMU.RUnlock()
+	if peer.headers()["Authorization"] != "Bearer 49" || peer.stickyAttributes()["reload"] != "49" || peer.requestedPollDuration() != 50*time.Second {
+		t.Fatalf("reload not applied: %v %v %s", peer.headers(), peer.stickyAttributes(), peer.requestedPollDuration())
+	}
+}
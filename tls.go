@@ -0,0 +1,205 @@
+package longpoll
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ClientTLSConfig TLS settings for requests to a server peer
+type ClientTLSConfig struct {
+	CAFile             string   // PEM CA bundle used to verify the server (empty uses the system roots)
+	CertFile           string   // PEM client certificate for mutual TLS
+	KeyFile            string   // PEM client key for mutual TLS
+	ServerName         string   // Server name to verify (empty uses the URL host)
+	PinnedSHA256       []string // Base64 SHA-256 hashes of accepted server public keys (SubjectPublicKeyInfo). Empty disables pinning
+	InsecureSkipVerify bool     // Skip certificate verification. Pins are still checked
+}
+
+// Build Creates a tls.Config from the settings
+func (c *ClientTLSConfig) Build() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	// Load the CA bundle
+	if c.CAFile != "" {
+		pool, err := loadCertPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	// Load the client certificate
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, errors.New("failed to load client certificate: " + err.Error())
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	// Check public key pins
+	if len(c.PinnedSHA256) > 0 {
+		pins := make(map[string]bool, len(c.PinnedSHA256))
+		for _, pin := range c.PinnedSHA256 {
+			pins[pin] = true
+		}
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					continue
+				}
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if pins[base64.StdEncoding.EncodeToString(sum[:])] {
+					return nil
+				}
+			}
+			return errors.New("server certificate does not match any pinned public key")
+		}
+	}
+	return config, nil
+}
+
+// Builds the server TLS config from TLSConfig, TLSCertFile, TLSKeyFile and TLSClientCAFile. Returns nil for plain HTTP
+func (m *Manager) serverTLSConfig() (*tls.Config, error) {
+	if m.TLSConfig == nil && m.TLSCertFile == "" && m.TLSClientCAFile == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if m.TLSConfig != nil {
+		config = m.TLSConfig.Clone()
+	}
+
+	// Load and watch the certificate
+	if m.TLSCertFile != "" || m.TLSKeyFile != "" {
+		reloader, err := newCertReloader(m.TLSCertFile, m.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.GetCertificate = reloader.getCertificate
+		m.certs = reloader
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return nil, errors.New("TLS requires a certificate (TLSCertFile and TLSKeyFile)")
+	}
+
+	// Verify client certificates
+	if m.TLSClientCAFile != "" {
+		pool, err := loadCertPool(m.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		if config.ClientAuth == tls.NoClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return config, nil
+}
+
+// Wraps a listener with TLS if configured
+func (m *Manager) tlsListener(ln net.Listener) (net.Listener, error) {
+	config, err := m.serverTLSConfig()
+	if err != nil || config == nil {
+		return ln, err
+	}
+	return tls.NewListener(ln, config), nil
+}
+
+// Reloads a certificate and key pair when either file changes on disk
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{}
+	err := r.load(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Check the files for changes at most once per second
+	if time.Since(r.lastCheck) > time.Second {
+		r.lastCheck = time.Now()
+		modTime, err := latestModTime(r.certFile, r.keyFile)
+		if err == nil && modTime.After(r.modTime) {
+			// Keep serving the old certificate if the new one is broken
+			certFile, keyFile := r.certFile, r.keyFile
+			r.mu.Unlock()
+			r.load(certFile, keyFile)
+			r.mu.Lock()
+		}
+	}
+	return r.cert, nil
+}
+
+// Loads a certificate and key pair and serves it from now on. The current pair is kept on failure
+func (r *certReloader) load(certFile string, keyFile string) error {
+	modTime, err := latestModTime(certFile, keyFile)
+	if err != nil {
+		return errors.New("failed to load certificate: " + err.Error())
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return errors.New("failed to load certificate: " + err.Error())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.certFile = certFile
+	r.keyFile = keyFile
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+func latestModTime(certFile string, keyFile string) (time.Time, error) {
+	certInfo, err := os.Stat(certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.New("failed to load CA bundle: " + err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("failed to load CA bundle: no certificates found in " + file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,185 @@
+package longpoll
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Generates a self-signed certificate for 127.0.0.1, writes it and its key as PEM files and returns the
+// certificate with its public key pin
+func writeTestCert(t *testing.T, certFile string, keyFile string) (*x509.Certificate, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "longpoll test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return cert, base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestClientTLSPinning(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_, pin := writeTestCert(t, certFile, keyFile)
+	_, otherPin := writeTestCert(t, filepath.Join(dir, "other.pem"), filepath.Join(dir, "other-key.pem"))
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		config ClientTLSConfig
+		ok     bool
+	}{
+		{"CA file", ClientTLSConfig{CAFile: certFile}, true},
+		{"CA file and matching pin", ClientTLSConfig{CAFile: certFile, PinnedSHA256: []string{otherPin, pin}}, true},
+		{"CA file and wrong pin", ClientTLSConfig{CAFile: certFile, PinnedSHA256: []string{otherPin}}, false},
+		{"skip verify and matching pin", ClientTLSConfig{InsecureSkipVerify: true, PinnedSHA256: []string{pin}}, true},
+		{"skip verify and wrong pin", ClientTLSConfig{InsecureSkipVerify: true, PinnedSHA256: []string{otherPin}}, false},
+		{"unknown authority", ClientTLSConfig{PinnedSHA256: []string{pin}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := tt.config.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestServerCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	first, _ := writeTestCert(t, certFile, keyFile)
+
+	m, err := NewManager(WithTLSFiles(certFile, keyFile, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Serve(ln)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Returns the certificate the server presents
+	served := func() *x509.Certificate {
+		t.Helper()
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0]
+	}
+	// Lets the next handshake check the files without waiting a second
+	recheck := func() {
+		m.certs.mu.Lock()
+		m.certs.lastCheck = time.Time{}
+		m.certs.mu.Unlock()
+	}
+	// Marks the files as changed
+	touch := func(d time.Duration) {
+		for _, file := range []string{certFile, keyFile} {
+			err := os.Chtimes(file, time.Now().Add(d), time.Now().Add(d))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if !served().Equal(first) {
+		t.Fatal("first certificate not served")
+	}
+
+	// Rotate the certificate and key
+	second, _ := writeTestCert(t, certFile, keyFile)
+	touch(time.Minute)
+	recheck()
+	if !served().Equal(second) {
+		t.Fatal("rotated certificate not served")
+	}
+
+	// A broken key file keeps the current certificate
+	err = os.WriteFile(keyFile, []byte("not a key"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	touch(2 * time.Minute)
+	recheck()
+	if !served().Equal(second) {
+		t.Fatal("certificate replaced by a broken key pair")
+	}
+}
@@ -0,0 +1,145 @@
+package longpoll
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// Message attributes carrying W3C trace context
+const (
+	TraceparentAttribute = "traceparent"
+	TracestateAttribute  = "tracestate"
+)
+
+// SpanContext A W3C trace context (https://www.w3.org/TR/trace-context/)
+type SpanContext struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	Flags      byte   // Trace flags eg: 0x01 for sampled
+	TraceState string // Vendor specific tracestate value
+}
+
+// Tracer Creates spans. Implement it to bridge to OpenTelemetry or another tracing system
+type Tracer interface {
+	// Start Starts a span as a child of the span context in ctx (if any) and returns a context carrying the new span
+	Start(ctx context.Context, name string, attributes map[string]string) (context.Context, Span)
+}
+
+// Span A span started by a Tracer
+type Span interface {
+	SpanContext() SpanContext
+	End(err error)
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext Returns a context carrying the span context. Tracers should use it so trace context is propagated
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext Returns the span context carried by ctx, if any
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// NewSpanContext Creates a sampled span context with a random span ID, continuing parent's trace if it is valid
+func NewSpanContext(parent SpanContext) SpanContext {
+	sc := SpanContext{Flags: 0x01}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Flags = parent.Flags
+		sc.TraceState = parent.TraceState
+	} else {
+		rand.Read(sc.TraceID[:])
+	}
+	rand.Read(sc.SpanID[:])
+	return sc
+}
+
+// IsValid Reports whether the trace and span IDs are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent Formats the span context as a traceparent value
+func (sc SpanContext) Traceparent() string {
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+// ParseTraceparent Parses traceparent and tracestate values
+func ParseTraceparent(traceparent string, tracestate string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	// Version 00 has exactly four fields
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	_, err := hex.Decode(sc.TraceID[:], []byte(parts[1]))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	_, err = hex.Decode(sc.SpanID[:], []byte(parts[2]))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.Flags = flags[0]
+	sc.TraceState = tracestate
+	return sc, sc.IsValid()
+}
+
+type noopSpan struct {
+	sc SpanContext
+}
+
+func (s noopSpan) SpanContext() SpanContext { return s.sc }
+func (s noopSpan) End(error)                {}
+
+// Starts a span with the manager tracer. Without a tracer the context is returned unchanged
+func (m *Manager) startSpan(ctx context.Context, name string, attributes map[string]string) (context.Context, Span) {
+	if m.Tracer == nil {
+		sc, _ := SpanContextFromContext(ctx)
+		return ctx, noopSpan{sc: sc}
+	}
+	return m.Tracer.Start(ctx, name, attributes)
+}
+
+// Returns a copy of attributes with the trace context in ctx injected
+func injectTrace(ctx context.Context, attributes map[string]string) map[string]string {
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok {
+		return attributes
+	}
+
+	injected := make(map[string]string, len(attributes)+2)
+	for k, v := range attributes {
+		injected[k] = v
+	}
+	injected[TraceparentAttribute] = sc.Traceparent()
+	if sc.TraceState != "" {
+		injected[TracestateAttribute] = sc.TraceState
+	} else {
+		delete(injected, TracestateAttribute)
+	}
+	return injected
+}
+
+// Returns a context carrying the trace context in the attributes of a message, if any
+func extractTrace(ctx context.Context, attributes map[string]string) context.Context {
+	sc, ok := ParseTraceparent(attributes[TraceparentAttribute], attributes[TracestateAttribute])
+	if !ok {
+		return ctx
+	}
+	return ContextWithSpanContext(ctx, sc)
+}
@@ -0,0 +1,176 @@
+package longpoll
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the spans it starts
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+type testSpan struct {
+	tracer *testTracer
+	name   string
+	parent SpanContext
+	sc     SpanContext
+	ended  bool
+}
+
+func (t *testTracer) Start(ctx context.Context, name string, attributes map[string]string) (context.Context, Span) {
+	parent, _ := SpanContextFromContext(ctx)
+	span := &testSpan{tracer: t, name: name, parent: parent, sc: NewSpanContext(parent)}
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return ContextWithSpanContext(ctx, span.sc), span
+}
+
+// Returns the first span with the name, if any, and whether it has ended
+func (t *testTracer) find(name string) (*testSpan, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, span := range t.spans {
+		if span.name == name {
+			return span, span.ended
+		}
+	}
+	return nil, false
+}
+
+func (s *testSpan) SpanContext() SpanContext { return s.sc }
+
+func (s *testSpan) End(err error) {
+	s.tracer.mu.Lock()
+	s.ended = true
+	s.tracer.mu.Unlock()
+}
+
+func TestTraceInjectExtract(t *testing.T) {
+	sc := NewSpanContext(SpanContext{})
+	sc.TraceState = "vendor=1"
+	ctx := ContextWithSpanContext(context.Background(), sc)
+
+	attributes := map[string]string{"a": "1"}
+	injected := injectTrace(ctx, attributes)
+	if injected[TraceparentAttribute] != sc.Traceparent() || injected[TracestateAttribute] != "vendor=1" || injected["a"] != "1" {
+		t.Fatalf("got attributes %v", injected)
+	}
+	if _, ok := attributes[TraceparentAttribute]; ok {
+		t.Fatal("injectTrace modified the attributes")
+	}
+
+	got, ok := SpanContextFromContext(extractTrace(context.Background(), injected))
+	if !ok || got != sc {
+		t.Fatalf("extracted %+v, want %+v", got, sc)
+	}
+
+	// Without a span context nothing is injected
+	injected = injectTrace(context.Background(), attributes)
+	if _, ok := injected[TraceparentAttribute]; ok {
+		t.Fatal("traceparent injected without a span context")
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		ok          bool
+	}{
+		{"valid", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"future version with extra fields", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"missing", "", false},
+		{"version 00 with extra fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"short trace ID", "00-4bf92f3577b34da6-00f067aa0ba902b7-01", false},
+		{"zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"zero span ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"not hex", "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", false},
+		{"bad flags", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok := ParseTraceparent(tt.traceparent, "")
+			if ok != tt.ok {
+				t.Fatalf("got ok %v, want %v", ok, tt.ok)
+			}
+
+			// Messages with an invalid or missing traceparent carry no trace context
+			ctx := extractTrace(context.Background(), map[string]string{TraceparentAttribute: tt.traceparent})
+			if _, ok := SpanContextFromContext(ctx); ok != tt.ok {
+				t.Fatalf("extracted ok %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTraceSendReceive(t *testing.T) {
+	serverTracer := &testTracer{}
+	server, err := NewManager(WithUUID("server"), WithPollLength(time.Second), WithTracer(serverTracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	received := make(chan SpanContext, 1)
+	release := make(chan struct{})
+	clientTracer := &testTracer{}
+	client, err := NewManager(WithUUID("client"), WithTracer(clientTracer), OnReceiveContext(func(ctx context.Context, peerUUID string, msg Message) {
+		sc, _ := SpanContextFromContext(ctx)
+		received <- sc
+		<-release
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+	err = client.AddServerPeer("server", ts.URL+server.API_Path, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return server.PeerExists("client") })
+
+	root := NewSpanContext(SpanContext{})
+	err = server.SendContext(ContextWithSpanContext(context.Background(), root), "client", "hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sc SpanContext
+	select {
+	case sc = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+
+	// The send span is a child of the root, the receive span a child of the send span
+	send, _ := serverTracer.find("longpoll.send")
+	if send == nil || send.parent != root {
+		t.Fatalf("send span %+v, want parent %+v", send, root)
+	}
+	receive, ended := clientTracer.find("longpoll.receive")
+	if receive == nil || receive.parent.SpanID != send.sc.SpanID || receive.parent.TraceID != root.TraceID {
+		t.Fatalf("receive span %+v, want parent %+v", receive, send.sc)
+	}
+	if sc != receive.sc {
+		t.Fatalf("callback got span context %+v, want %+v", sc, receive.sc)
+	}
+
+	// The receive span ends after the callback returns
+	if ended {
+		t.Fatal("receive span ended before the callback returned")
+	}
+	close(release)
+	waitFor(t, func() bool {
+		_, ended := clientTracer.find("longpoll.receive")
+		return ended
+	})
+}
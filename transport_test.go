@@ -0,0 +1,181 @@
+package longpoll
+
+import (
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransportConfigMerge(t *testing.T) {
+	base := &TransportConfig{ProxyURL: "http://proxy:3128", DialTimeout: time.Second, MaxIdleConns: 5}
+	tests := []struct {
+		name     string
+		base     *TransportConfig
+		override *TransportConfig
+		want     TransportConfig
+	}{
+		{"both nil", nil, nil, TransportConfig{}},
+		{"no override", base, nil, *base},
+		{"no base", nil, &TransportConfig{KeepAlive: time.Minute}, TransportConfig{KeepAlive: time.Minute}},
+		{
+			"non-zero values override",
+			base,
+			&TransportConfig{ProxyURL: ProxyDirect, MaxIdleConns: 7, MaxConnsPerHost: 2},
+			TransportConfig{ProxyURL: ProxyDirect, DialTimeout: time.Second, MaxIdleConns: 7, MaxConnsPerHost: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.base.merge(tt.override)
+			if *got != tt.want {
+				t.Fatalf("got %+v, want %+v", *got, tt.want)
+			}
+			if got == tt.base {
+				t.Fatal("merge returned the base settings")
+			}
+		})
+	}
+	if base.ProxyURL != "http://proxy:3128" || base.MaxIdleConns != 5 {
+		t.Fatalf("merge modified the base settings: %+v", *base)
+	}
+}
+
+func TestPeerClientPrecedence(t *testing.T) {
+	m, err := NewManager(WithTransportConfig(TransportConfig{MaxIdleConns: 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without an HTTPClient the transport is built from the peer's settings over the manager's
+	client, err := m.peerClient(ServerPeerConfig{Transport: &TransportConfig{MaxConnsPerHost: 4}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok || transport.MaxIdleConns != 3 || transport.MaxConnsPerHost != 4 {
+		t.Fatalf("got transport %+v", client.Transport)
+	}
+	if client.Jar == nil {
+		t.Fatal("client has no cookie jar")
+	}
+
+	// The HTTPClient's transport and jar take precedence, its timeout is cleared
+	userTransport := &http.Transport{}
+	userJar, _ := cookiejar.New(nil)
+	user := &http.Client{Transport: userTransport, Jar: userJar, Timeout: time.Second}
+	client, err = m.peerClient(ServerPeerConfig{HTTPClient: user, Transport: &TransportConfig{MaxConnsPerHost: 4}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Transport != userTransport || client.Jar != userJar {
+		t.Fatal("HTTPClient transport or jar replaced")
+	}
+	if client.Timeout != 0 {
+		t.Fatalf("got timeout %s, want 0", client.Timeout)
+	}
+	if user.Timeout != time.Second {
+		t.Fatal("HTTPClient modified")
+	}
+
+	// An HTTPClient without a transport gets the peer's
+	client, err = m.peerClient(ServerPeerConfig{HTTPClient: &http.Client{}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.Transport.(*http.Transport); !ok || client.Jar == nil {
+		t.Fatal("HTTPClient without a transport or jar not given the peer's")
+	}
+}
+
+func TestPeerClientCheckRedirect(t *testing.T) {
+	m, err := NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "http://standby.example/poll", nil)
+
+	// Redirects are left to the peer
+	client, err := m.peerClient(ServerPeerConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CheckRedirect(req, nil); !errors.Is(err, http.ErrUseLastResponse) {
+		t.Fatalf("got %v, want ErrUseLastResponse", err)
+	}
+
+	// The HTTPClient's CheckRedirect runs first and can reject the redirect
+	errRejected := errors.New("rejected")
+	var checked []string
+	user := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		checked = append(checked, req.URL.Host)
+		if req.URL.Host == "evil.example" {
+			return errRejected
+		}
+		return nil
+	}}
+	client, err = m.peerClient(ServerPeerConfig{HTTPClient: user}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CheckRedirect(req, nil); !errors.Is(err, http.ErrUseLastResponse) {
+		t.Fatalf("got %v, want ErrUseLastResponse", err)
+	}
+	if err := client.CheckRedirect(httptest.NewRequest("GET", "http://evil.example/poll", nil), nil); !errors.Is(err, errRejected) {
+		t.Fatalf("got %v, want the HTTPClient's error", err)
+	}
+	if len(checked) != 2 {
+		t.Fatalf("HTTPClient CheckRedirect called %d times, want 2", len(checked))
+	}
+}
+
+func TestPeerCookieJarIsolation(t *testing.T) {
+	// The server gives each peer a cookie naming it and records the cookies sent back
+	var mu sync.Mutex
+	mismatched := 0
+	returned := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get("X-Peer")
+		cookie, err := r.Cookie("session")
+		mu.Lock()
+		if err == nil {
+			returned[name] = true
+			if cookie.Value != name {
+				mismatched++
+			}
+		}
+		mu.Unlock()
+		if err != nil {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: name})
+		}
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	m, err := NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	for _, name := range []string{"a", "b"} {
+		err := m.AddServerPeerWithConfig(name, ServerPeerConfig{URL: server.URL, Headers: map[string]string{"X-Peer": name}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return returned["a"] && returned["b"]
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if mismatched != 0 {
+		t.Fatalf("%d requests sent another peer's cookie", mismatched)
+	}
+}
@@ -1,30 +1,95 @@
 package longpoll
 
 import (
-	"net/http/cookiejar"
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Manager Settings are read without synchronization: configure them with NewManager options (or set the
+// fields before Start) and don't change them afterwards. Change state at runtime through methods eg:
+// AddServerPeer, DeletePeer, SetTopics, SetPeerStickyAttributes and AddEventListener
 type Manager struct {
-	UUID      string
-	peers     map[string]*Peer
-	peersMU   sync.RWMutex
-	cookieJar *cookiejar.Jar
-
-	API_Port           int              // Port to listen on
-	API_Path           string           // Path to listen on eg: /poll
-	API_Middleware     *gin.HandlerFunc // Middleware to run before each request
-	PollLength         time.Duration    // Time before a poll should be refreshed
-	PeerExpiry         time.Duration    // Time before a peer is considered expired/offline
-	Deadline           time.Duration    // Time before a poll times out
-	OutboundBufferSize int              // Size of outbound message buffers
-
-	UpCallback      *func(peerUUID string)              // Function to call when a peer comes online
-	DownCallback    *func(peerUUID string)              // Function to call when a peer goes offline
-	ReceiveCallback *func(peerUUID string, msg Message) // Function to call when receiving a message
+	UUID        string
+	peers       map[string]*Peer
+	peersMU     sync.RWMutex
+	server      *http.Server
+	adminServer *http.Server
+	serverMU    sync.Mutex
+	stop        chan struct{}
+	stopOnce    sync.Once
+
+	transportMU     sync.Mutex
+	sharedTransport *http.Transport // Built from TransportConfig on first use (see baseTransport)
+
+	allowedNets []*net.IPNet
+	deniedNets  []*net.IPNet
+	limiter     *rateLimiter
+	metrics     *metricsRegistry
+	events      *eventBus
+	serving     atomic.Bool
+	retryAfter  atomic.Int64  // Retry-After hint for polling clients (see SetRetryAfter)
+	certs       *certReloader // Serves TLSCertFile and TLSKeyFile, nil unless they are set
+	settingsMU  sync.RWMutex  // Guards the settings Reload can change
+	config      *Config       // Last config applied by NewManagerFromConfig or Reload
+	drainURL    string        // Where client peers are redirected while draining (see Drain), guarded by settingsMU
+
+	API_Port           int               // Port to listen on
+	API_Path           string            // Path to listen on eg: /poll
+	API_Middleware     gin.HandlerFunc   // Middleware to run before each request
+	PollLength         time.Duration     // Time before a poll should be refreshed
+	MinPollLength      time.Duration     // Shortest poll duration a client may request (see PollDurationHeader)
+	MaxPollLength      time.Duration     // Longest poll duration a client may request (see PollDurationHeader)
+	PeerExpiry         time.Duration     // Time before a peer is considered expired/offline
+	Deadline           time.Duration     // Time before a poll times out
+	OutboundBufferSize int               // Size of outbound message buffers
+	Transport          http.RoundTripper // Transport used for requests to server peers (nil uses TransportConfig)
+	TransportConfig    *TransportConfig  // Connection pooling, proxy and dial settings for requests to server peers (nil uses http.DefaultTransport)
+
+	CircuitBreakerThreshold int           // Consecutive failed requests before a server peer's circuit breaker opens (0 disables)
+	CircuitBreakerTimeout   time.Duration // Time an open circuit breaker fails fast before letting a request through
+
+	Authenticator     Authenticator   // Verifies the identity of peers (nil trusts the uuid header)
+	MaxPeers          int             // Maximum number of peers (0 is unlimited)
+	MaxPeersPerIP     int             // Maximum number of client peers per IP address (0 is unlimited)
+	AllowedIPs        []string        // IPs or CIDRs allowed to connect (empty allows all). Parsed by Start
+	DeniedIPs         []string        // IPs or CIDRs denied from connecting. Parsed by Start
+	RateLimits        RateLimits      // Token bucket rate limits for the poll API (zero values are unlimited)
+	InboundLimits     InboundLimits   // Size and format limits for inbound requests and messages
+	TLSConfig         *tls.Config     // Serve the API over HTTPS with this config (nil serves plain HTTP unless TLSCertFile is set)
+	TLSCertFile       string          // PEM certificate to serve, reloaded when it changes on disk
+	TLSKeyFile        string          // PEM key to serve, reloaded when it changes on disk
+	TLSClientCAFile   string          // PEM CA bundle used to verify client certificates (enables mutual TLS)
+	KeyStore          KeyStore        // Keys used to sign, verify, encrypt and decrypt messages
+	SignMessages      bool            // Sign outgoing messages with KeyStore.SigningKey
+	EncryptMessages   bool            // Encrypt outgoing message data with KeyStore.EncryptionKey
+	RequireSignatures bool            // Reject incoming messages without a valid signature
+	RequireEncryption bool            // Reject incoming messages that are not encrypted
+	TopicAuthorizer   TopicAuthorizer // Decides who may subscribe and publish to topics (nil allows all)
+	MetricsPath       string          // Serve Prometheus metrics on this path of the API eg: /metrics (empty disables)
+	HealthChecks      bool            // Serve /healthz and /readyz on the API
+	Tracer            Tracer          // Creates spans for sends, polls and receives (nil only propagates trace context)
+	AdminPath         string          // Serve the admin API under this path of the API eg: /admin (empty disables)
+	AdminPort         int             // Serve the admin API on its own port (0 disables)
+	AdminMiddleware   gin.HandlerFunc // Middleware to run before each admin request eg: authentication (required with AdminPath or AdminPort)
+	Logger            *slog.Logger    // Logger for lifecycle events and errors (nil uses slog.Default). Use a handler level to filter or discard
+
+	AdmissionHook    func(r *http.Request, peerUUID string) error        // Function to call before creating a new client peer, return an error to reject it
+	PeerSettingsHook func(r *http.Request, peerUUID string) PeerSettings // Function to call when creating a new client peer to choose its settings
+
+	UpCallback      func(peerUUID string)                           // Function to call when a peer comes online
+	DownCallback    func(peerUUID string)                           // Function to call when a peer goes offline
+	ReceiveCallback func(peerUUID string, msg Message)              // Function to call when receiving a message
+	RejectCallback  func(peerUUID string, ipAddr string, err error) // Function to call when a request is rejected
+
+	ReceiveContextCallback func(ctx context.Context, peerUUID string, msg Message) // Function to call when receiving a message, ctx carries its trace context
 }
 
 type Message struct {
@@ -32,23 +97,63 @@ type Message struct {
 	Attributes  map[string]string `json:"attributes"`
 	MessageID   string            `json:"message_id"`
 	PublishTime time.Time         `json:"publish_time"`
+	Signature   []byte            `json:"signature,omitempty"` // ed25519 signature (see Manager.SignMessages)
+	Encrypted   bool              `json:"encrypted,omitempty"` // Data is AES-GCM encrypted (see Manager.EncryptMessages)
 }
 
 type Peer struct {
-	UUID             string // Unique identifier for this peer
-	ipAddr           string
-	Ch               chan Message // Buffered channel for outgoing messages to client peers
-	LastConsumed     time.Time    // Last time this client peer consumed a message
-	upCallback       *func(string)
-	downCallback     *func(string)
-	receiveCallback  *func(string, Message)
+	UUID             string            // Unique identifier for this peer
+	ipAddr           string            // Guarded by stateMU
+	stateMU          sync.RWMutex      // Guards ipAddr, LastConsumed, remoteManagerUUID and sessionToken
+	Ch               chan Message      // Buffered channel for outgoing messages to client peers
+	LastConsumed     time.Time         // Last time this client peer consumed a message. Guarded by stateMU
 	Topics           []string          // Topics this peer is subscribed to (see FanOutSubscribers())
-	StickyAttrbitues map[string]string // Attributes to be appended to every outgoing message
+	StickyAttrbitues map[string]string // Attributes to be appended to every outgoing message. Guarded by settingsMU
+	queue            []Message         // Copy of the messages in Ch, see queue.go
+	queueMU          sync.Mutex
+	space            chan struct{} // Closed when a message leaves a full queue
+	removed          chan struct{} // Closed when the peer is deleted
+	removeOnce       sync.Once
+	settings         PeerSettings // Overrides for the manager settings (see SetPeerSettings)
+	settingsMU       sync.RWMutex // Guards settings, ServerURL, Headers, StickyAttrbitues, pollDuration, redirectHosts and client
 
 	// Specific to server peers
-	IsServer          bool
-	ServerURL         string            // URL of server running longpoll API
-	Headers           map[string]string // Headers to be applied to outgoing requests
-	Online            bool
-	remoteManagerUUID string
+	IsServer              bool
+	ServerURL             string            // URL of server running longpoll API, the last URL used if it has several
+	endpoints             *endpointSet      // URLs of the server and their health
+	Headers               map[string]string // Headers to be applied to outgoing requests. Guarded by settingsMU
+	Online                bool
+	remoteManagerUUID     string           // Guarded by stateMU
+	sessionToken          string           // Guarded by stateMU
+	client                *http.Client     // Client for requests to the server, with its own cookie jar
+	transportConfig       *TransportConfig // Transport settings the client was built from
+	pollDuration          time.Duration    // Poll duration requested from the server. Guarded by settingsMU
+	redirectHosts         []string         // Extra hosts followRedirect may move to. Guarded by settingsMU
+	effectivePollDuration time.Duration    // Poll duration the server last replied with
+	retryAfter            time.Duration    // Retry-After hint from the last poll reply
+	tlsConfig             *ClientTLSConfig // TLS settings the transport was built from
+	required              bool             // Manager is not ready while this peer is offline
+
+	// Poll health of server peers
+	breaker             circuitBreaker
+	healthMU            sync.Mutex
+	lastSuccess         time.Time
+	consecutiveFailures int
+	lastError           string
+}
+
+// ServerPeerConfig Settings for a server peer (see AddServerPeerWithConfig)
+type ServerPeerConfig struct {
+	URL              string            // URL of server running longpoll API
+	URLs             []string          // More URLs of the same server to fail over or balance across, after URL
+	Strategy         EndpointStrategy  // How to pick between URLs (default StrategyFailover)
+	Headers          map[string]string // Headers to be applied to outgoing requests
+	StickyAttributes map[string]string // Attributes to be appended to every outgoing message
+	TLS              *ClientTLSConfig  // TLS settings for this server (nil uses Manager.Transport)
+	Transport        *TransportConfig  // Proxy, pooling and dial settings for this server, merged over Manager.TransportConfig
+	HTTPClient       *http.Client      // Client for requests to this server. Its Transport and Jar take precedence over TLS and Transport, its CheckRedirect can reject redirects and its Timeout is ignored
+	Required         bool              // Report the manager as not ready while this server is offline (see ReadyHandler)
+	Settings         PeerSettings      // Overrides PollLength (retry delay) and Deadline for this server
+	PollDuration     time.Duration     // Poll duration to request from the server (0 lets the server choose)
+	RedirectHosts    []string          // Hosts a draining server may redirect to eg: standby:8080. The hosts of URL and URLs are always allowed
 }
@@ -1,163 +1,276 @@
 package longpoll
 
 import (
-	"encoding/json"
-	"io"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func (m *Manager) handleGET(c *gin.Context) {
-	// Get the peer UUID
-	uuid := c.Request.Header.Get("uuid")
-	if uuid == "" {
-		c.JSON(400, gin.H{
-			"error": "uuid is required",
-		})
+	// Identify the peer
+	peer, created := m.admitPeer(c)
+	if peer == nil {
 		return
 	}
-
-	// Set manager UUID in response headers
-	c.Header("uuid", m.UUID)
-
-	// Does the peer exist?
-	m.peersMU.RLock()
-	peer, _ := m.peers[uuid]
-	m.peersMU.RUnlock()
-	if peer == nil {
-		// Create a new peer
-		ch := make(chan Message, m.OutboundBufferSize)
-		newPeer := &Peer{
-			UUID:            uuid,
-			Ch:              ch,
-			Online:          true,
-			LastConsumed:    time.Now(),
-			upCallback:      m.UpCallback,
-			downCallback:    m.DownCallback,
-			receiveCallback: m.ReceiveCallback,
-		}
-		m.peersMU.Lock()
-		m.peers[uuid] = newPeer
-		m.peersMU.Unlock()
-		peer = newPeer
-
-		// Call the manager up callback
-		if m.UpCallback != nil {
-			cb := *m.UpCallback
-			go cb(uuid)
-		}
-
+	if !created && m.handleDrainGET(c, peer) {
+		return
+	}
+	wait := m.negotiatePollLength(c, peer)
+	if created {
 		// Reply 201 to indicate that the peer has been created
 		c.Status(201)
 		return
 	}
 
-	// Update the peer ipAddress
-	peer.ipAddr = c.ClientIP()
-
 	// Send available message or wait
+	_, span := m.startSpan(c.Request.Context(), "longpoll.poll_wait", map[string]string{"peer": peer.UUID})
+	defer span.End(nil)
+	start := time.Now()
 	select {
 	case msg := <-peer.Ch:
-		peer.LastConsumed = time.Now()
+		peer.dequeued()
+		peer.consumed()
+		m.metrics.observe("longpoll_poll_wait_seconds", metricLabels("result", "message"), time.Since(start).Seconds())
 		c.JSON(200, msg)
 		return
-	case <-time.After(m.PollLength):
-		peer.LastConsumed = time.Now()
+	case <-time.After(wait):
+		peer.consumed()
+		m.metrics.observe("longpoll_poll_wait_seconds", metricLabels("result", "timeout"), time.Since(start).Seconds())
+		m.writeRetryAfter(c)
 		c.Status(204)
 		return
 	case <-c.Request.Context().Done():
+		m.metrics.observe("longpoll_poll_wait_seconds", metricLabels("result", "cancelled"), time.Since(start).Seconds())
+		return
+	case <-peer.removed:
+		// Peer was deleted or kicked, it will be re-created on the next poll
+		m.metrics.observe("longpoll_poll_wait_seconds", metricLabels("result", "removed"), time.Since(start).Seconds())
+		m.writeRetryAfter(c)
+		c.Status(204)
 		return
 	}
 }
 
 func (m *Manager) handlePOST(c *gin.Context) {
+	// Identify the peer
+	peer, _ := m.admitPeer(c)
+	if peer == nil {
+		return
+	}
+
+	// Read the request body
+	if limit := m.InboundLimits.MaxBodySize; limit > 0 && c.Request.ContentLength > limit {
+		m.reject(c, peer.UUID, ErrBodyTooLarge)
+		return
+	}
+	body, err := readLimited(c.Request.Body, m.InboundLimits.MaxBodySize)
+	if errors.Is(err, ErrBodyTooLarge) {
+		m.reject(c, peer.UUID, err)
+		return
+	}
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": "failed to read request body",
+		})
+		return
+	}
+
+	// Check the byte rate limits
+	err = m.checkByteRate(peer.UUID, c.ClientIP(), len(body))
+	if err != nil {
+		m.reject(c, peer.UUID, err)
+		return
+	}
+
+	// Parse the message
+	msg, err := decodeMessage(body, m.InboundLimits)
+	if err != nil {
+		m.reject(c, peer.UUID, err)
+		return
+	}
+
+	// Verify and decrypt the message
+	msg, err = m.openMessage(peer.UUID, msg)
+	if err != nil {
+		m.reject(c, peer.UUID, err)
+		return
+	}
+
+	// Check the peer may publish to the topic of the message
+	if topic, ok := msg.Attributes[TopicAttribute]; ok && m.TopicAuthorizer != nil {
+		err = m.TopicAuthorizer.CanPublish(peer.UUID, topic)
+		if err != nil {
+			m.reject(c, peer.UUID, topicDenied("publish", topic, err))
+			return
+		}
+	}
+
+	// Call the manager receive callbacks
+	m.receive(peer.UUID, msg)
+	c.Status(200)
+}
+
+// Identifies the peer making a request, creating it if it does not exist.
+// Returns a nil peer if the request was rejected and a response has been sent
+func (m *Manager) admitPeer(c *gin.Context) (*Peer, bool) {
 	// Get the peer UUID
 	uuid := c.Request.Header.Get("uuid")
+	if identifier, ok := m.Authenticator.(IdentityAuthenticator); ok && uuid == "" {
+		uuid, _ = identifier.Identity(c.Request)
+	}
 	if uuid == "" {
 		c.JSON(400, gin.H{
 			"error": "uuid is required",
 		})
-		return
+		return nil, false
 	}
 
 	// Set manager UUID in response headers
 	c.Header("uuid", m.UUID)
 
+	// Check the peer UUID format
+	err := m.InboundLimits.checkUUID(uuid)
+	if err != nil {
+		m.reject(c, uuid, err)
+		return nil, false
+	}
+
+	// Check the IP allow and deny lists
+	ipAddr := c.ClientIP()
+	err = m.checkIP(ipAddr)
+	if err != nil {
+		m.reject(c, uuid, err)
+		return nil, false
+	}
+
+	// Check the per-IP request rate limit
+	err = m.checkIPRequestRate(c.Request.Method, ipAddr)
+	if err != nil {
+		m.reject(c, uuid, err)
+		return nil, false
+	}
+
+	// Authenticate the peer
+	known := m.PeerExists(uuid)
+	if m.Authenticator != nil {
+		verified, err := m.Authenticator.Authenticate(c.Request, uuid, known)
+		if err == nil && verified != uuid {
+			err = ErrForbidden
+		}
+		if err != nil {
+			m.reject(c, uuid, err)
+			return nil, false
+		}
+	}
+
+	// Check the per-peer request rate limit now the peer is authenticated
+	err = m.checkPeerRequestRate(c.Request.Method, uuid)
+	if err != nil {
+		m.reject(c, uuid, err)
+		return nil, false
+	}
+
+	// Redirect new peers while draining
+	if target, draining := m.Draining(); draining && !known {
+		c.Header("Location", target)
+		c.Status(http.StatusTemporaryRedirect)
+		return nil, false
+	}
+
+	// Run the admission and settings hooks for new peers
+	var settings PeerSettings
+	if !known {
+		err := m.checkAdmissionHook(c.Request, uuid)
+		if err != nil {
+			m.reject(c, uuid, err)
+			return nil, false
+		}
+		settings, err = m.newPeerSettings(c.Request, uuid)
+		if err != nil {
+			m.reject(c, uuid, err)
+			return nil, false
+		}
+	}
+
 	// Does the peer exist?
-	m.peersMU.RLock()
+	created := false
+	m.peersMU.Lock()
 	peer, _ := m.peers[uuid]
-	m.peersMU.RUnlock()
 	if peer == nil {
-		// Create a new peer
-		ch := make(chan Message, m.OutboundBufferSize)
-		newPeer := &Peer{
-			UUID:            uuid,
-			ipAddr:          c.ClientIP(),
-			Online:          true,
-			Ch:              ch,
-			LastConsumed:    time.Now(),
-			upCallback:      m.UpCallback,
-			downCallback:    m.DownCallback,
-			receiveCallback: m.ReceiveCallback,
+		// Check peer limits
+		err := m.checkPeerLimits(ipAddr)
+		if err != nil {
+			m.peersMU.Unlock()
+			m.reject(c, uuid, err)
+			return nil, false
 		}
-		m.peersMU.Lock()
-		m.peers[uuid] = newPeer
-		m.peersMU.Unlock()
-		peer = newPeer
 
-		// Call the manager up callback
-		if m.UpCallback != nil {
-			cb := *m.UpCallback
-			go cb(uuid)
+		// Create a new peer
+		peer = &Peer{
+			UUID:         uuid,
+			ipAddr:       ipAddr,
+			Ch:           make(chan Message, settings.outboundBufferSize(m)),
+			Online:       true,
+			LastConsumed: time.Now(),
+			removed:      make(chan struct{}),
+			settings:     settings,
 		}
+		m.peers[uuid] = peer
+		created = true
 	}
+	m.peersMU.Unlock()
 
-	// Read the request body
-	body, err := io.ReadAll(c.Request.Body)
-	if err != nil {
-		c.JSON(400, gin.H{
-			"error": "failed to read request body",
-		})
-		return
-	}
+	// Update the peer ipAddress
+	peer.setIPAddr(ipAddr)
 
-	// Parse the message
-	var msg Message
-	err = json.Unmarshal(body, &msg)
-	if err != nil {
-		c.JSON(400, gin.H{
-			"error": "failed to parse message",
-		})
-		return
+	// Issue a fresh session token if applicable
+	if issuer, ok := m.Authenticator.(SessionIssuer); ok {
+		token, err := issuer.IssueSession(uuid)
+		if err != nil {
+			m.emit(Event{Type: EventError, PeerUUID: uuid, IPAddr: ipAddr, Reason: "issue_session", Err: err})
+		} else {
+			c.Header(SessionHeader, token)
+		}
 	}
 
-	// Call the manager receive callback
-	if m.ReceiveCallback != nil {
-		cb := *m.ReceiveCallback
-		go cb(uuid, msg)
+	// Call the manager up callback
+	if created {
+		m.emit(Event{Type: EventPeerCreated, PeerUUID: uuid, IPAddr: ipAddr})
+		m.emit(Event{Type: EventPeerOnline, PeerUUID: uuid, IPAddr: ipAddr})
+		if m.UpCallback != nil {
+			go m.UpCallback(uuid)
+		}
 	}
-	c.Status(200)
+	return peer, created
 }
 
 // Deletes peers that have expired
 func (m *Manager) garbageCollectPeers() {
+	// Forget idle rate limit buckets
+	m.limiter.prune()
+
 	m.peersMU.Lock()
 	defer m.peersMU.Unlock()
-	for key, peer := range m.peers {
+	for _, peer := range m.peers {
 		// Skip servers
 		if peer.IsServer {
 			continue
 		}
 
 		// Check if the peer has expired
-		if time.Since(peer.LastConsumed) > m.PeerExpiry {
+		if time.Since(peer.getLastConsumed()) > peer.peerExpiry(m) {
+			m.emit(Event{Type: EventPeerExpired, PeerUUID: peer.UUID, IPAddr: peer.getIPAddr()})
+			m.emit(Event{Type: EventPeerOffline, PeerUUID: peer.UUID, IPAddr: peer.getIPAddr(), Reason: ReasonExpired})
 			if m.DownCallback != nil {
-				cb := *m.DownCallback
-				go cb(peer.UUID)
+				go m.DownCallback(peer.UUID)
 			}
-			close(peer.Ch)
-			delete(m.peers, key)
+			m.metrics.add("longpoll_peers_expired_total", "", 1)
+			m.metrics.add("longpoll_messages_expired_total", "", float64(len(peer.Ch)))
+			m.removePeer(peer)
 		}
 	}
 }
@@ -168,3 +281,55 @@ func stringPlaceHolder(s string) string {
 	}
 	return s
 }
+
+// Returns a copy of attributes with the sticky attributes applied
+func mergeAttributes(attributes map[string]string, sticky map[string]string) map[string]string {
+	if len(sticky) == 0 {
+		return attributes
+	}
+	merged := make(map[string]string, len(attributes)+len(sticky))
+	for k, v := range attributes {
+		merged[k] = v
+	}
+	for k, v := range sticky {
+		merged[k] = v
+	}
+	return merged
+}
+
+// Checks if the peer is subscribed to a topic
+func (p *Peer) subscribed(topic string) bool {
+	for _, t := range p.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// Calls the receive callbacks for a message, with the trace context from its attributes
+func (m *Manager) receive(peerUUID string, msg Message) {
+	ctx := extractTrace(context.Background(), msg.Attributes)
+	ctx, span := m.startSpan(ctx, "longpoll.receive", map[string]string{"peer": peerUUID, "message_id": msg.MessageID})
+
+	// Run the callbacks concurrently, the span ends once they return
+	var wg sync.WaitGroup
+	if m.ReceiveCallback != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.ReceiveCallback(peerUUID, msg)
+		}()
+	}
+	if m.ReceiveContextCallback != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.ReceiveContextCallback(ctx, peerUUID, msg)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		span.End(nil)
+	}()
+}